@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAdvisoryLockHeld 锁被其他会话持有，在等待时间内没能拿到
+	ErrAdvisoryLockHeld = errors.New("advisory lock is held by another session")
+	// ErrLeaseNotFound 租约不存在或已经释放
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrLeaseLost 租约已失效：TTL 到期没有续约，或持锁连接断开
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// lostLeaseRetention 失效的租约保留多久，这段时间内续约或释放返回 ErrLeaseLost 而不是 ErrLeaseNotFound
+const lostLeaseRetention = 10 * time.Minute
+
+// lostLease 已失效租约的墓碑
+type lostLease struct {
+	reason string
+	at     time.Time
+}
+
+// advisoryKey 把锁名哈希成 pg_advisory_lock 使用的 int64 键
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// LeaseInfo 租约的对外信息
+type LeaseInfo struct {
+	Token      string    `json:"token"`
+	Name       string    `json:"name"`
+	Key        int64     `json:"key"`
+	PID        int       `json:"pid"` // 持锁连接的后端进程 ID
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Lost       string    `json:"lost,omitempty"` // 失效原因
+}
+
+// Lease 一把会话级 advisory lock 的租约
+// 锁由一个专用连接持有，持有者需要在 TTL 内续约，否则后台协程会主动释放
+type Lease struct {
+	LeaseInfo
+
+	mu          sync.Mutex
+	release     chan struct{}
+	releaseOnce sync.Once
+	done        chan struct{}
+}
+
+// snapshot 复制一份可以安全序列化的租约信息
+func (l *Lease) snapshot() LeaseInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.LeaseInfo
+}
+
+// AdvisoryLocker 基于 Postgres advisory lock 的跨进程互斥锁
+type AdvisoryLocker struct {
+	db        *gorm.DB
+	heartbeat time.Duration
+
+	mu     sync.Mutex
+	leases map[string]*Lease
+	lost   map[string]lostLease
+}
+
+func NewAdvisoryLocker(db *gorm.DB, heartbeat time.Duration) *AdvisoryLocker {
+	return &AdvisoryLocker{
+		db:        db,
+		heartbeat: heartbeat,
+		leases:    map[string]*Lease{},
+		lost:      map[string]lostLease{},
+	}
+}
+
+func newLeaseToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Acquire 获取名为 name 的锁，最多等待 wait，成功后返回 TTL 为 ttl 的租约
+// 锁持有在 db.Connection 固定下来的连接上，连接断开时 Postgres 会自动释放锁
+func (l *AdvisoryLocker) Acquire(ctx context.Context, name string, ttl, wait time.Duration) (*Lease, error) {
+	lease := &Lease{
+		LeaseInfo: LeaseInfo{
+			Token: newLeaseToken(),
+			Name:  name,
+			Key:   advisoryKey(name),
+		},
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	acquired := make(chan error, 1)
+
+	// 租约的生命周期比请求长，所以持锁连接不能使用请求的 ctx
+	go func() {
+		defer close(lease.done)
+		locked := false
+		err := l.db.WithContext(context.Background()).Connection(func(conn *gorm.DB) error {
+			deadline := time.Now().Add(wait)
+			for {
+				var ok bool
+				if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lease.Key).Scan(&ok).Error; err != nil {
+					return err
+				}
+				if ok {
+					break
+				}
+				if time.Now().After(deadline) {
+					return ErrAdvisoryLockHeld
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			var pid int
+			conn.Raw("SELECT pg_backend_pid()").Scan(&pid)
+			lease.mu.Lock()
+			lease.PID = pid
+			lease.AcquiredAt = time.Now()
+			lease.ExpiresAt = lease.AcquiredAt.Add(ttl)
+			lease.mu.Unlock()
+			locked = true
+			// 先登记再通知 Acquire，hold 很快返回时也能在下面移除
+			l.mu.Lock()
+			l.leases[lease.Token] = lease
+			l.mu.Unlock()
+			acquired <- nil
+
+			return l.hold(conn, lease)
+		})
+		// 拿锁阶段失败时把错误交给 Acquire；hold 返回时锁已经释放，租约从表里移除。
+		// 过期或断开的租约留下墓碑，持有者之后续约或释放时能知道锁已经丢了
+		if !locked {
+			acquired <- err
+			return
+		}
+		if err != nil {
+			lease.mu.Lock()
+			lease.Lost = err.Error()
+			lease.mu.Unlock()
+			l.markLost(lease.Token, err.Error())
+			return
+		}
+		l.forget(lease.Token)
+	}()
+
+	if err := <-acquired; err != nil {
+		return nil, err
+	}
+	fmt.Printf("advisory lock 已获取: %s (key=%d, pid=%d)\n", name, lease.Key, lease.PID)
+	return lease, nil
+}
+
+// hold 在持锁连接上定期心跳，直到租约被释放、过期或连接断开
+func (l *AdvisoryLocker) hold(conn *gorm.DB, lease *Lease) error {
+	ticker := time.NewTicker(l.heartbeat)
+	defer ticker.Stop()
+
+	unlock := func() error {
+		return conn.Exec("SELECT pg_advisory_unlock(?)", lease.Key).Error
+	}
+
+	for {
+		select {
+		case <-lease.release:
+			return unlock()
+		case <-ticker.C:
+			lease.mu.Lock()
+			expired := time.Now().After(lease.ExpiresAt)
+			lease.mu.Unlock()
+			if expired {
+				unlock()
+				fmt.Printf("advisory lock 租约过期，已释放: %s\n", lease.Name)
+				return fmt.Errorf("%w: ttl expired without renewal", ErrLeaseLost)
+			}
+			// 心跳失败说明连接已经断开，服务端会自动释放 session 级 advisory lock
+			if err := conn.Exec("SELECT 1").Error; err != nil {
+				fmt.Printf("advisory lock 连接断开: %s, 错误: %v\n", lease.Name, err)
+				return fmt.Errorf("%w: connection dropped: %v", ErrLeaseLost, err)
+			}
+		}
+	}
+}
+
+// lease 按 token 查找仍然有效的租约，最近失效的租约返回 ErrLeaseLost
+func (l *AdvisoryLocker) lease(token string) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease, ok := l.leases[token]; ok {
+		return lease, nil
+	}
+	if lost, ok := l.lost[token]; ok && time.Since(lost.at) < lostLeaseRetention {
+		return nil, fmt.Errorf("%w: %s", ErrLeaseLost, lost.reason)
+	}
+	return nil, ErrLeaseNotFound
+}
+
+func (l *AdvisoryLocker) forget(token string) {
+	l.mu.Lock()
+	delete(l.leases, token)
+	l.mu.Unlock()
+}
+
+// markLost 移除租约并留下墓碑，顺便清理超过保留时间的墓碑
+func (l *AdvisoryLocker) markLost(token, reason string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.leases, token)
+	for t, lost := range l.lost {
+		if now.Sub(lost.at) >= lostLeaseRetention {
+			delete(l.lost, t)
+		}
+	}
+	l.lost[token] = lostLease{reason: reason, at: now}
+}
+
+// Renew 续约，把过期时间延长到现在 + ttl
+func (l *AdvisoryLocker) Renew(token string, ttl time.Duration) (LeaseInfo, error) {
+	lease, err := l.lease(token)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	lease.mu.Lock()
+	lease.ExpiresAt = time.Now().Add(ttl)
+	lease.mu.Unlock()
+	return lease.snapshot(), nil
+}
+
+// Release 主动释放锁
+func (l *AdvisoryLocker) Release(token string) error {
+	lease, err := l.lease(token)
+	if err != nil {
+		return err
+	}
+	lease.releaseOnce.Do(func() { close(lease.release) })
+	<-lease.done
+	l.forget(token)
+	fmt.Printf("advisory lock 已释放: %s\n", lease.Name)
+	return nil
+}
+
+// Leases 当前进程持有的所有租约
+func (l *AdvisoryLocker) Leases() []LeaseInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	leases := make([]LeaseInfo, 0, len(l.leases))
+	for _, lease := range l.leases {
+		leases = append(leases, lease.snapshot())
+	}
+	return leases
+}
+
+// AdvisoryLockInfo pg_locks 中的一条 advisory lock 记录 (包括其他进程持有的)
+type AdvisoryLockInfo struct {
+	PID             int     `json:"pid"`
+	Key             int64   `json:"key"`
+	Mode            string  `json:"mode"`
+	Granted         bool    `json:"granted"`
+	ApplicationName string  `json:"application_name"`
+	ClientAddr      *string `json:"client_addr"`
+	Query           string  `json:"query"`
+}
+
+// advisoryLocks 查询数据库中所有 advisory lock，key 不为 0 时只查这一个键
+// bigint 键在 pg_locks 中拆成 classid (高 32 位) 和 objid (低 32 位)
+func advisoryLocks(db *gorm.DB, key int64) ([]AdvisoryLockInfo, error) {
+	locks := []AdvisoryLockInfo{}
+	query := db.Table("pg_locks l").
+		Select(`l.pid, ((l.classid::bigint << 32) | l.objid::bigint) AS key, l.mode, l.granted,
+			a.application_name, a.client_addr::text AS client_addr, a.query`).
+		Joins("JOIN pg_stat_activity a ON a.pid = l.pid").
+		Where("l.locktype = 'advisory' AND l.objsubid = 1 AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())")
+	if key != 0 {
+		query = query.Where("((l.classid::bigint << 32) | l.objid::bigint) = ?", key)
+	}
+	err := query.Order("l.granted DESC, l.pid").Scan(&locks).Error
+	return locks, err
+}
+
+// tryXactLock 在事务内尝试获取事务级 advisory lock，事务提交或回滚时自动释放
+func tryXactLock(tx *gorm.DB, name string) (bool, error) {
+	var ok bool
+	err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", advisoryKey(name)).Scan(&ok).Error
+	return ok, err
+}
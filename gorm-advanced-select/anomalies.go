@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AnomalyResult 某个场景在某个隔离级别下的运行结果
+type AnomalyResult struct {
+	Scenario  string `json:"scenario"`
+	Isolation string `json:"isolation"`
+	Observed  bool   `json:"observed"`        // 是否观察到了异常
+	Detail    string `json:"detail"`          // 观察到的数据
+	Error     string `json:"error,omitempty"` // 被数据库拦下时的错误，例如 40001
+}
+
+// anomalyScenario 两个并发事务交替执行的脚本，a、b 是两条临时用户记录的 ID
+type anomalyScenario struct {
+	Name string
+	Run  func(db *gorm.DB, level sql.IsolationLevel, a, b uint) AnomalyResult
+}
+
+var anomalyScenarios = []anomalyScenario{
+	{Name: "lost_update", Run: runLostUpdate},
+	{Name: "non_repeatable_read", Run: runNonRepeatableRead},
+	{Name: "write_skew", Run: runWriteSkew},
+}
+
+// 场景使用的初始年龄
+const anomalyInitialAge = 10
+
+func readAge(tx *gorm.DB, id uint) (int, error) {
+	var age int
+	err := tx.Raw("SELECT age FROM users WHERE id = ?", id).Scan(&age).Error
+	return age, err
+}
+
+func setAge(tx *gorm.DB, id uint, age int) error {
+	return tx.Model(&User{}).Where("id = ?", id).Update("age", age).Error
+}
+
+// runLostUpdate T1、T2 都先读出 age，再各自写回 age+1，T2 的写入覆盖 T1 就是丢失更新
+func runLostUpdate(db *gorm.DB, level sql.IsolationLevel, a, _ uint) AnomalyResult {
+	res := AnomalyResult{Scenario: "lost_update"}
+	t1 := db.Begin(&sql.TxOptions{Isolation: level})
+	t2 := db.Begin(&sql.TxOptions{Isolation: level})
+	defer t1.Rollback()
+	defer t2.Rollback()
+
+	age1, err := readAge(t1, a)
+	if err == nil {
+		var age2 int
+		if age2, err = readAge(t2, a); err == nil {
+			if err = setAge(t1, a, age1+1); err == nil {
+				if err = t1.Commit().Error; err == nil {
+					if err = setAge(t2, a, age2+1); err == nil {
+						err = t2.Commit().Error
+					}
+				}
+			}
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	final, _ := readAge(db, a)
+	res.Observed = err == nil && final == anomalyInitialAge+1
+	res.Detail = fmt.Sprintf("两个事务各自 +1，最终 age=%d (期望 %d)", final, anomalyInitialAge+2)
+	return res
+}
+
+// runNonRepeatableRead T1 两次读取同一行，中间 T2 修改并提交，两次结果不同就是不可重复读
+func runNonRepeatableRead(db *gorm.DB, level sql.IsolationLevel, a, _ uint) AnomalyResult {
+	res := AnomalyResult{Scenario: "non_repeatable_read"}
+	t1 := db.Begin(&sql.TxOptions{Isolation: level})
+	t2 := db.Begin(&sql.TxOptions{Isolation: level})
+	defer t1.Rollback()
+	defer t2.Rollback()
+
+	var first, second int
+	var err error
+	if first, err = readAge(t1, a); err == nil {
+		if err = t2.Model(&User{}).Where("id = ?", a).Update("age", gorm.Expr("age + 1")).Error; err == nil {
+			if err = t2.Commit().Error; err == nil {
+				if second, err = readAge(t1, a); err == nil {
+					err = t1.Commit().Error
+				}
+			}
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	res.Observed = err == nil && first != second
+	res.Detail = fmt.Sprintf("T1 第一次读 age=%d，T2 提交后第二次读 age=%d", first, second)
+	return res
+}
+
+// runWriteSkew 约束是两人年龄之和不少于 anomalyInitialAge，
+// T1、T2 都检查总和后各自把自己那一行减掉 anomalyInitialAge，两者都提交就破坏了约束
+func runWriteSkew(db *gorm.DB, level sql.IsolationLevel, a, b uint) AnomalyResult {
+	res := AnomalyResult{Scenario: "write_skew"}
+	t1 := db.Begin(&sql.TxOptions{Isolation: level})
+	t2 := db.Begin(&sql.TxOptions{Isolation: level})
+	defer t1.Rollback()
+	defer t2.Rollback()
+
+	sumAge := func(tx *gorm.DB) (int, error) {
+		var sum int
+		err := tx.Raw("SELECT COALESCE(SUM(age), 0) FROM users WHERE id IN ?", []uint{a, b}).Scan(&sum).Error
+		return sum, err
+	}
+
+	var sum1, sum2 int
+	var err error
+	if sum1, err = sumAge(t1); err == nil {
+		if sum2, err = sumAge(t2); err == nil {
+			if sum1-anomalyInitialAge >= anomalyInitialAge && sum2-anomalyInitialAge >= anomalyInitialAge {
+				if err = t1.Model(&User{}).Where("id = ?", a).Update("age", gorm.Expr("age - ?", anomalyInitialAge)).Error; err == nil {
+					if err = t2.Model(&User{}).Where("id = ?", b).Update("age", gorm.Expr("age - ?", anomalyInitialAge)).Error; err == nil {
+						if err = t1.Commit().Error; err == nil {
+							err = t2.Commit().Error
+						}
+					}
+				}
+			}
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	final, _ := sumAge(db)
+	res.Observed = err == nil && final < anomalyInitialAge
+	res.Detail = fmt.Sprintf("两个事务都看到总和 %d/%d，最终总和=%d (约束 >= %d)", sum1, sum2, final, anomalyInitialAge)
+	return res
+}
+
+// runAnomalyScenarios 在每个隔离级别下运行所选场景，每次运行都使用新建的临时用户
+func runAnomalyScenarios(db *gorm.DB, names []string, levels []string) ([]AnomalyResult, error) {
+	selected := anomalyScenarios
+	if len(names) > 0 {
+		selected = nil
+		for _, name := range names {
+			found := false
+			for _, s := range anomalyScenarios {
+				if s.Name == name {
+					selected = append(selected, s)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown scenario %q", name)
+			}
+		}
+	}
+	if len(levels) == 0 {
+		levels = []string{"read_committed", "repeatable_read", "serializable"}
+	}
+
+	var results []AnomalyResult
+	for _, name := range levels {
+		level, ok := isolationLevels[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown isolation level %q", name)
+		}
+		for _, s := range selected {
+			users := []User{
+				{Name: "anomaly-a", Age: anomalyInitialAge, LockTest: s.Name},
+				{Name: "anomaly-b", Age: anomalyInitialAge, LockTest: s.Name},
+			}
+			if err := db.Create(&users).Error; err != nil {
+				return nil, err
+			}
+
+			res := s.Run(db, level, users[0].ID, users[1].ID)
+			res.Isolation = strings.ToUpper(level.String())
+			results = append(results, res)
+
+			db.Unscoped().Delete(&users)
+		}
+	}
+
+	printAnomalyResults(results)
+	return results, nil
+}
+
+// printAnomalyResults 在控制台打印结果表格
+func printAnomalyResults(results []AnomalyResult) {
+	fmt.Printf("%-20s %-16s %-8s %s\n", "场景", "隔离级别", "异常", "详情")
+	for _, r := range results {
+		observed := "否"
+		if r.Observed {
+			observed = "是"
+		}
+		detail := r.Detail
+		if r.Error != "" {
+			detail += " | 被拦截: " + r.Error
+		}
+		fmt.Printf("%-20s %-16s %-8s %s\n", r.Scenario, r.Isolation, observed, detail)
+	}
+}
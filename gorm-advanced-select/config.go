@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+)
+
+// Config 服务端配置，通过环境变量覆盖默认值
+type Config struct {
+	// MaxLatency 锁演示接口允许客户端请求的最长持锁时间 (LOCK_MAX_LATENCY)
+	MaxLatency time.Duration
+	// RequestTimeout 单个请求在服务端的最长执行时间，超过后回滚事务 (LOCK_REQUEST_TIMEOUT)
+	RequestTimeout time.Duration
+	// JobWorkers 任务队列 worker 数量，0 表示不启动 (JOB_WORKERS)
+	JobWorkers int
+	// JobVisibilityTimeout 任务被领取后多久没有完成就可以被重新领取 (JOB_VISIBILITY_TIMEOUT)
+	JobVisibilityTimeout time.Duration
+}
+
+func loadConfig() (Config, error) {
+	cfg := Config{
+		MaxLatency:           30 * time.Second,
+		JobWorkers:           2,
+		JobVisibilityTimeout: 30 * time.Second,
+	}
+
+	if s := os.Getenv("LOCK_MAX_LATENCY"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid LOCK_MAX_LATENCY %q", s)
+		}
+		cfg.MaxLatency = d
+	}
+
+	// 默认给持锁时间之外再留 10 秒用于等锁和执行 SQL
+	cfg.RequestTimeout = cfg.MaxLatency + 10*time.Second
+	if s := os.Getenv("LOCK_REQUEST_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid LOCK_REQUEST_TIMEOUT %q", s)
+		}
+		cfg.RequestTimeout = d
+	}
+
+	if s := os.Getenv("JOB_WORKERS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid JOB_WORKERS %q", s)
+		}
+		cfg.JobWorkers = n
+	}
+	if s := os.Getenv("JOB_VISIBILITY_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid JOB_VISIBILITY_TIMEOUT %q", s)
+		}
+		cfg.JobVisibilityTimeout = d
+	}
+	return cfg, nil
+}
+
+// sleepContext 可取消的等待，用来模拟持锁期间的业务处理
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// respondCanceled 请求上下文已结束时返回对应的状态码，返回 false 表示上下文仍然有效
+func respondCanceled(c *gin.Context, ctx context.Context, id string) bool {
+	err := ctx.Err()
+	if err == nil {
+		return false
+	}
+
+	fmt.Printf("请求已取消，事务回滚, ID: %s, 原因: %v\n", id, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		apierror.Respond(c, apierror.New(http.StatusGatewayTimeout, apierror.CodeTimeout, "超过服务端处理时限，事务已回滚").
+			WithDetails(err.Error()))
+		return true
+	}
+	// 客户端主动断开时返回 499 (nginx 约定)，实际上客户端已经收不到了
+	apierror.Respond(c, err)
+	return true
+}
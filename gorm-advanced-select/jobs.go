@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"gorm-shared/apierror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 任务状态
+const (
+	JobPending = "pending" // 等待执行 (包括等待重试)
+	JobRunning = "running" // 已被 worker 领取
+	JobDone    = "done"    // 执行成功
+	JobDead    = "dead"    // 重试次数用尽，进入死信
+)
+
+// JSONPayload 存在 jsonb 列里的任务参数
+type JSONPayload []byte
+
+func (p JSONPayload) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "{}", nil
+	}
+	return string(p), nil
+}
+
+func (p *JSONPayload) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*p = append((*p)[:0], v...)
+	case string:
+		*p = JSONPayload(v)
+	case nil:
+		*p = nil
+	default:
+		return fmt.Errorf("invalid type %T for JSONPayload", value)
+	}
+	return nil
+}
+
+func (p JSONPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *JSONPayload) UnmarshalJSON(b []byte) error {
+	*p = append((*p)[:0], b...)
+	return nil
+}
+
+// Decode 把参数解析到 v，参数为空时什么也不做
+func (p JSONPayload) Decode(v interface{}) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p, v)
+}
+
+// Job jobs 表，(queue, status, run_at) 上的索引用于 worker 领取任务
+type Job struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	Queue       string      `json:"queue" gorm:"not null;default:default;index:idx_jobs_claim,priority:1"`
+	Type        string      `json:"type" gorm:"not null"`
+	Payload     JSONPayload `json:"payload" gorm:"type:jsonb;not null;default:'{}'"`
+	Priority    int         `json:"priority" gorm:"not null;default:0"` // 越大越先执行
+	Status      string      `json:"status" gorm:"not null;default:pending;index:idx_jobs_claim,priority:2"`
+	RunAt       time.Time   `json:"run_at" gorm:"not null;index:idx_jobs_claim,priority:3"`
+	Attempts    int         `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int         `json:"max_attempts" gorm:"not null;default:5"`
+	LockedBy    *string     `json:"locked_by"`
+	LockedUntil *time.Time  `json:"locked_until"` // 可见性超时，过了这个时间还没完成就会被其他 worker 重新领取
+	LastError   *string     `json:"last_error"`
+	FinishedAt  *time.Time  `json:"finished_at"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// JobHandler 处理某一类任务，返回错误时任务会按退避策略重试
+type JobHandler func(ctx context.Context, job *Job) error
+
+// JobQueue 基于 FOR UPDATE SKIP LOCKED 的任务队列
+type JobQueue struct {
+	db                *gorm.DB
+	handlers          map[string]JobHandler
+	VisibilityTimeout time.Duration
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
+	PollInterval      time.Duration
+}
+
+func NewJobQueue(db *gorm.DB, visibilityTimeout time.Duration) *JobQueue {
+	return &JobQueue{
+		db:                db,
+		handlers:          map[string]JobHandler{},
+		VisibilityTimeout: visibilityTimeout,
+		BaseBackoff:       time.Second,
+		MaxBackoff:        5 * time.Minute,
+		PollInterval:      time.Second,
+	}
+}
+
+func (q *JobQueue) Register(jobType string, handler JobHandler) {
+	q.handlers[jobType] = handler
+}
+
+// EnqueueRequest 入队参数，RunAt 和 Delay 都为空时立即可执行
+type EnqueueRequest struct {
+	Queue       string      `json:"queue"`
+	Type        string      `json:"type" binding:"required"`
+	Payload     JSONPayload `json:"payload"`
+	Priority    int         `json:"priority"`
+	RunAt       *time.Time  `json:"run_at"`
+	Delay       string      `json:"delay"` // 如 "30s"，与 RunAt 二选一
+	MaxAttempts int         `json:"max_attempts"`
+}
+
+func (q *JobQueue) Enqueue(ctx context.Context, req EnqueueRequest) (*Job, error) {
+	job := &Job{
+		Queue:       req.Queue,
+		Type:        req.Type,
+		Payload:     req.Payload,
+		Priority:    req.Priority,
+		RunAt:       time.Now(),
+		MaxAttempts: req.MaxAttempts,
+	}
+	if job.Queue == "" {
+		job.Queue = "default"
+	}
+	switch {
+	case req.RunAt != nil:
+		job.RunAt = *req.RunAt
+	case req.Delay != "":
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			return nil, apierror.BadRequest("无效的 delay: %q", req.Delay)
+		}
+		job.RunAt = job.RunAt.Add(d)
+	}
+	if err := q.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim 领取最多 n 个可执行的任务
+// 待执行且到期的任务，以及可见性超时的运行中任务都可以被领取；
+// SKIP LOCKED 让多个 worker 并发领取时互不阻塞，也不会领到同一个任务
+func (q *JobQueue) Claim(ctx context.Context, queue, worker string, n int) ([]Job, error) {
+	var jobs []Job
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("queue = ?", queue).
+			Where("((status = ? AND run_at <= now()) OR (status = ? AND locked_until < now()))", JobPending, JobRunning).
+			Order("priority DESC, run_at, id").
+			Limit(n).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(jobs))
+		for i := range jobs {
+			ids[i] = jobs[i].ID
+		}
+		until := time.Now().Add(q.VisibilityTimeout)
+		if err := tx.Model(&Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       JobRunning,
+			"locked_by":    worker,
+			"locked_until": until,
+			"attempts":     gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		for i := range jobs {
+			jobs[i].Status = JobRunning
+			jobs[i].LockedBy = &worker
+			jobs[i].LockedUntil = &until
+			jobs[i].Attempts++
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// owned 只更新仍然属于该 worker 的那次领取，防止可见性超时后被别人领走的任务被旧 worker 覆盖
+func (q *JobQueue) owned(ctx context.Context, job *Job) *gorm.DB {
+	return q.db.WithContext(ctx).Model(&Job{}).
+		Where("id = ? AND locked_by = ? AND attempts = ? AND status = ?", job.ID, *job.LockedBy, job.Attempts, JobRunning)
+}
+
+func (q *JobQueue) complete(ctx context.Context, job *Job) error {
+	now := time.Now()
+	result := q.owned(ctx, job).Updates(map[string]interface{}{
+		"status":       JobDone,
+		"finished_at":  now,
+		"locked_by":    nil,
+		"locked_until": nil,
+		"last_error":   nil,
+	})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return fmt.Errorf("job %d: lost ownership before completion", job.ID)
+	}
+	return result.Error
+}
+
+// backoff 第 attempts 次失败后的重试间隔，指数增长并带随机抖动
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	d := q.BaseBackoff << (attempts - 1)
+	if d <= 0 || d > q.MaxBackoff {
+		d = q.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// fail 记录失败，还有重试次数就延后重新排队，否则进入死信
+func (q *JobQueue) fail(ctx context.Context, job *Job, cause error) error {
+	msg := cause.Error()
+	values := map[string]interface{}{
+		"last_error":   msg,
+		"locked_by":    nil,
+		"locked_until": nil,
+	}
+	if job.Attempts >= job.MaxAttempts {
+		values["status"] = JobDead
+		values["finished_at"] = time.Now()
+	} else {
+		values["status"] = JobPending
+		values["run_at"] = time.Now().Add(q.backoff(job.Attempts))
+	}
+	return q.owned(ctx, job).Updates(values).Error
+}
+
+// process 执行一个已领取的任务，处理时间不能超过这次领取的租约 (locked_until)
+// 同一批领取的任务租约相同，前面的任务执行得久，后面的任务可能还没开始租约就到期了，
+// 这时它可能已经被别的 worker 领走，直接跳过，避免同一个任务执行两次
+func (q *JobQueue) process(ctx context.Context, job *Job) {
+	if job.LockedUntil == nil || !time.Now().Before(*job.LockedUntil) {
+		fmt.Printf("任务租约已过期, ID: %d, 留给下一次领取\n", job.ID)
+		return
+	}
+
+	if job.Attempts > job.MaxAttempts {
+		// 可见性超时后被重新领取，但重试次数已经用完 (例如 worker 反复崩溃)
+		q.fail(ctx, job, fmt.Errorf("exceeded max attempts after visibility timeout"))
+		return
+	}
+
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		q.fail(ctx, job, fmt.Errorf("no handler registered for job type %q", job.Type))
+		return
+	}
+
+	jobCtx, cancel := context.WithDeadline(ctx, *job.LockedUntil)
+	err := handler(jobCtx, job)
+	cancel()
+
+	if err != nil {
+		fmt.Printf("任务失败, ID: %d, 类型: %s, 第 %d 次, 错误: %v\n", job.ID, job.Type, job.Attempts, err)
+		if ferr := q.fail(ctx, job, err); ferr != nil {
+			fmt.Printf("记录任务失败出错, ID: %d, 错误: %v\n", job.ID, ferr)
+		}
+		return
+	}
+	if err := q.complete(ctx, job); err != nil {
+		fmt.Printf("完成任务出错, ID: %d, 错误: %v\n", job.ID, err)
+		return
+	}
+	fmt.Printf("任务完成, ID: %d, 类型: %s, 第 %d 次\n", job.ID, job.Type, job.Attempts)
+}
+
+// StartWorkers 启动 n 个 worker 轮询 queue，每次最多领取 batch 个任务，ctx 取消后停止
+func (q *JobQueue) StartWorkers(ctx context.Context, queue string, n, batch int) *sync.WaitGroup {
+	host, _ := os.Hostname()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		worker := fmt.Sprintf("%s:%d:worker-%d", host, os.Getpid(), i+1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				jobs, err := q.Claim(ctx, queue, worker, batch)
+				if err != nil && ctx.Err() == nil {
+					fmt.Printf("%s 领取任务失败: %v\n", worker, err)
+				}
+				for i := range jobs {
+					q.process(ctx, &jobs[i])
+				}
+				if len(jobs) == 0 {
+					sleepContext(ctx, q.PollInterval)
+				}
+			}
+		}()
+	}
+	return &wg
+}
+
+// Requeue 把任务重新放回队列立即执行，通常用于死信任务
+func (q *JobQueue) Requeue(ctx context.Context, id uint) (Job, error) {
+	var job Job
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&job, id).Error; err != nil {
+			return err
+		}
+		if job.Status == JobRunning {
+			return ErrJobRunning
+		}
+		// RETURNING 把更新后的行写回 job
+		return tx.Model(&job).Clauses(clause.Returning{}).Updates(map[string]interface{}{
+			"status":       JobPending,
+			"run_at":       time.Now(),
+			"attempts":     0,
+			"locked_by":    nil,
+			"locked_until": nil,
+			"finished_at":  nil,
+		}).Error
+	})
+	return job, err
+}
+
+// ErrJobRunning 正在执行的任务不能重新入队
+var ErrJobRunning = errors.New("job is running")
+
+// JobStats 每个队列每种状态的任务数
+type JobStats struct {
+	Queue  string `json:"queue"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+func (q *JobQueue) Stats(ctx context.Context) ([]JobStats, error) {
+	stats := []JobStats{}
+	err := q.db.WithContext(ctx).Model(&Job{}).
+		Select("queue, status, count(*) AS count").
+		Group("queue, status").
+		Order("queue, status").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// registerDemoJobHandlers 演示用的任务类型
+func registerDemoJobHandlers(q *JobQueue) {
+	// echo: 打印参数
+	q.Register("echo", func(ctx context.Context, job *Job) error {
+		fmt.Printf("echo 任务 %d: %s\n", job.ID, job.Payload)
+		return nil
+	})
+
+	// sleep: {"seconds": 3}，模拟耗时任务，超过可见性超时会被取消
+	q.Register("sleep", func(ctx context.Context, job *Job) error {
+		var p struct {
+			Seconds int `json:"seconds"`
+		}
+		if err := job.Payload.Decode(&p); err != nil {
+			return err
+		}
+		return sleepContext(ctx, time.Duration(p.Seconds)*time.Second)
+	})
+
+	// flaky: {"fail_times": 2}，前 fail_times 次执行失败，用于演示重试和死信
+	q.Register("flaky", func(ctx context.Context, job *Job) error {
+		var p struct {
+			FailTimes int `json:"fail_times"`
+		}
+		if err := job.Payload.Decode(&p); err != nil {
+			return err
+		}
+		if job.Attempts <= p.FailTimes {
+			return fmt.Errorf("simulated failure %d/%d", job.Attempts, p.FailTimes)
+		}
+		return nil
+	})
+}
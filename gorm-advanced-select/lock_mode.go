@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm-shared/apierror"
+	"gorm-shared/replica"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 加锁等待方式
+const (
+	LockWaitBlock      = "wait"        // 一直等待，直到拿到锁
+	LockWaitNoWait     = "nowait"      // 拿不到锁立即报错
+	LockWaitSkipLocked = "skip_locked" // 跳过已被锁住的行
+	LockWaitTimeout    = "timeout"     // 最多等待 lock_timeout
+)
+
+// 加锁强度，对应 FOR UPDATE / FOR NO KEY UPDATE / FOR SHARE / FOR KEY SHARE
+var lockStrengths = map[string]string{
+	"update":        clause.LockingStrengthUpdate,
+	"no_key_update": "NO KEY UPDATE",
+	"share":         clause.LockingStrengthShare,
+	"key_share":     "KEY SHARE",
+}
+
+const defaultLockTimeout = 3 * time.Second
+
+// Postgres 加锁失败的错误码：55P03 lock_not_available (NOWAIT 和 lock_timeout 都会返回)
+const pgLockNotAvailable = "55P03"
+
+// ErrRowSkipped SKIP LOCKED 模式下目标行已被其他事务锁住而被跳过
+var ErrRowSkipped = errors.New("row is locked by another transaction and was skipped")
+
+// LockMode 描述一次行锁请求的方式
+type LockMode struct {
+	Wait     string
+	Strength string
+	Timeout  time.Duration
+}
+
+func (m LockMode) String() string {
+	s := "FOR " + lockStrengths[m.Strength]
+	switch m.Wait {
+	case LockWaitNoWait:
+		s += " NOWAIT"
+	case LockWaitSkipLocked:
+		s += " SKIP LOCKED"
+	case LockWaitTimeout:
+		s += fmt.Sprintf(" (lock_timeout=%s)", m.Timeout)
+	}
+	return s
+}
+
+// parseLockMode 从查询参数解析加锁方式
+// ?mode=wait|nowait|skip_locked|timeout&strength=update|no_key_update|share|key_share&timeout=3s
+func parseLockMode(c *gin.Context) (LockMode, error) {
+	mode := LockMode{
+		Wait:     strings.ToLower(c.DefaultQuery("mode", LockWaitBlock)),
+		Strength: strings.ToLower(c.DefaultQuery("strength", "update")),
+	}
+
+	switch mode.Wait {
+	case LockWaitBlock, LockWaitNoWait, LockWaitSkipLocked:
+	case LockWaitTimeout:
+		mode.Timeout = defaultLockTimeout
+		if s := c.Query("timeout"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return mode, fmt.Errorf("invalid timeout %q", s)
+			}
+			mode.Timeout = d
+		}
+	default:
+		return mode, fmt.Errorf("unknown lock mode %q", mode.Wait)
+	}
+
+	if _, ok := lockStrengths[mode.Strength]; !ok {
+		return mode, fmt.Errorf("unknown lock strength %q", mode.Strength)
+	}
+	return mode, nil
+}
+
+// Clause 转换成 GORM 的 clause.Locking
+func (m LockMode) Clause() clause.Locking {
+	locking := clause.Locking{Strength: lockStrengths[m.Strength]}
+	switch m.Wait {
+	case LockWaitNoWait:
+		locking.Options = clause.LockingOptionsNoWait
+	case LockWaitSkipLocked:
+		locking.Options = clause.LockingOptionsSkipLocked
+	}
+	return locking
+}
+
+// lockUser 在事务 tx 中按指定方式锁住一行用户记录
+func lockUser(tx *gorm.DB, id string, mode LockMode) (User, error) {
+	var user User
+
+	if mode.Wait == LockWaitTimeout {
+		// SET LOCAL 不支持参数绑定，用 set_config(..., true) 代替，只在当前事务内生效
+		if err := tx.Exec("SELECT set_config('lock_timeout', ?, true)", fmt.Sprintf("%dms", mode.Timeout.Milliseconds())).Error; err != nil {
+			return user, err
+		}
+	}
+
+	err := tx.Clauses(mode.Clause()).Where("id = ?", id).Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && mode.Wait == LockWaitSkipLocked {
+		// SKIP LOCKED 查不到行时，需要区分是行不存在还是被跳过了
+		// 普通 SELECT 不会被行锁阻塞，直接在当前事务里检查即可
+		var count int64
+		if cerr := tx.Model(&User{}).Where("id = ?", id).Count(&count).Error; cerr == nil && count > 0 {
+			return user, ErrRowSkipped
+		}
+	}
+	return user, err
+}
+
+// isLockNotAvailable 判断是否是 NOWAIT / lock_timeout 导致的加锁失败
+func isLockNotAvailable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgLockNotAvailable
+}
+
+// rowLockHolders 查询当前持有某一行行锁的后端进程 pid
+// 行锁记录在元组的 xmax 上而不是 pg_locks 里，所以用 xmax 去匹配持有者的事务 ID 锁；
+// 多个事务同时持有 FOR SHARE 时 xmax 是 MultiXact，这种情况下可能查不到
+func rowLockHolders(db *gorm.DB, id string) []int {
+	var pids []int
+	db.Raw(`SELECT l.pid
+		FROM users u
+		JOIN pg_locks l ON l.locktype = 'transactionid' AND l.transactionid = u.xmax AND l.granted
+		WHERE u.id = ?`, id).Scan(&pids)
+	return pids
+}
+
+// respondLockError 按加锁失败的原因返回 409 / 423，并带上阻塞者的 pid
+func respondLockError(c *gin.Context, db *gorm.DB, id string, mode LockMode, err error) bool {
+	var apiErr *apierror.Error
+	switch {
+	case errors.Is(err, ErrRowSkipped):
+		apiErr = apierror.New(http.StatusConflict, apierror.CodeConflict, "记录已被其他事务锁住")
+	case isLockNotAvailable(err):
+		apiErr = apierror.New(http.StatusLocked, apierror.CodeLocked, "记录已被其他事务锁住")
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		apierror.Respond(c, apierror.NotFound("用户 %s 不存在", id))
+		return true
+	default:
+		return false
+	}
+
+	fmt.Printf("加锁失败, ID: %s, 方式: %s, 错误: %v\n", id, mode, err)
+	apierror.Respond(c, apiErr.WithDetails(gin.H{
+		"cause":         err.Error(),
+		"lock_mode":     mode.String(),
+		"blocking_pids": rowLockHolders(replica.Primary(db), id), // 锁只在主库上可见
+	}))
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LockHolder 持有 users 表行锁的会话
+// FOR UPDATE / FOR SHARE 会在表上加 RowShareLock，UPDATE 会加 RowExclusiveLock
+type LockHolder struct {
+	PID             int        `json:"pid"`
+	Username        string     `json:"username"`
+	ApplicationName string     `json:"application_name"`
+	ClientAddr      *string    `json:"client_addr"`
+	State           *string    `json:"state"`
+	XID             *string    `json:"xid" gorm:"column:xid"`
+	Mode            string     `json:"mode"`
+	XactStart       *time.Time `json:"xact_start"`
+	XactSeconds     *float64   `json:"xact_seconds"`
+	WaitEventType   *string    `json:"wait_event_type"`
+	WaitEvent       *string    `json:"wait_event"`
+	Query           string     `json:"query"`
+}
+
+// LockWait 一条等待关系：WaitingPID 正在等待 BlockingPID 释放锁
+type LockWait struct {
+	WaitingPID          int      `json:"waiting_pid" gorm:"column:waiting_pid"`
+	WaitingQuery        string   `json:"waiting_query"`
+	WaitEventType       *string  `json:"wait_event_type"`
+	WaitEvent           *string  `json:"wait_event"`
+	WaitingSeconds      *float64 `json:"waiting_seconds"`
+	BlockingPID         int      `json:"blocking_pid" gorm:"column:blocking_pid"`
+	BlockingState       *string  `json:"blocking_state"`
+	BlockingQuery       string   `json:"blocking_query"`
+	BlockingXactSeconds *float64 `json:"blocking_xact_seconds"`
+}
+
+// LockSnapshot 某一时刻 users 表上的锁情况
+type LockSnapshot struct {
+	At      time.Time    `json:"at"`
+	Holders []LockHolder `json:"holders"`
+	Waits   []LockWait   `json:"waits"`
+}
+
+// 持有 users 表行级锁的会话（排除查询自身）
+// 排队等行锁的会话也已经拿到了表上的 RowShareLock，所以还要排除被别人阻塞的会话，它们出现在 waits 里
+const lockHoldersSQL = `
+SELECT a.pid, a.usename AS username, a.application_name, a.client_addr::text AS client_addr,
+       a.state, a.backend_xid::text AS xid, l.mode, a.xact_start,
+       EXTRACT(EPOCH FROM now() - a.xact_start)::float8 AS xact_seconds,
+       a.wait_event_type, a.wait_event, a.query
+FROM pg_locks l
+JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'relation'
+  AND l.relation = 'users'::regclass
+  AND l.granted
+  AND l.mode IN ('RowShareLock', 'RowExclusiveLock')
+  AND a.pid <> pg_backend_pid()
+  AND cardinality(pg_blocking_pids(a.pid)) = 0
+ORDER BY a.xact_start`
+
+// 正在等待 users 表上锁的会话，以及 pg_blocking_pids() 给出的阻塞者
+const lockWaitsSQL = `
+SELECT w.pid AS waiting_pid, w.query AS waiting_query, w.wait_event_type, w.wait_event,
+       EXTRACT(EPOCH FROM now() - w.query_start)::float8 AS waiting_seconds,
+       b.pid AS blocking_pid, b.state AS blocking_state, b.query AS blocking_query,
+       EXTRACT(EPOCH FROM now() - b.xact_start)::float8 AS blocking_xact_seconds
+FROM pg_stat_activity w
+CROSS JOIN LATERAL unnest(pg_blocking_pids(w.pid)) AS bp(pid)
+JOIN pg_stat_activity b ON b.pid = bp.pid
+WHERE w.datname = current_database()
+  AND EXISTS (
+    SELECT 1 FROM pg_locks l
+    WHERE l.pid = w.pid
+      AND l.locktype IN ('relation', 'tuple')
+      AND l.relation = 'users'::regclass
+  )
+ORDER BY w.query_start, b.pid`
+
+// lockSnapshot 查询 pg_locks / pg_stat_activity，得到当前谁持有锁、谁在等待
+func lockSnapshot(db *gorm.DB) (LockSnapshot, error) {
+	snapshot := LockSnapshot{
+		At:      time.Now(),
+		Holders: []LockHolder{},
+		Waits:   []LockWait{},
+	}
+	if err := db.Raw(lockHoldersSQL).Scan(&snapshot.Holders).Error; err != nil {
+		return snapshot, err
+	}
+	if err := db.Raw(lockWaitsSQL).Scan(&snapshot.Waits).Error; err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
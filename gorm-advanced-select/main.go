@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+	"gorm-shared/listquery"
+	"gorm-shared/replica"
+	"gorm-shared/txretry"
+	"gorm-shared/versioning"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,9 +28,15 @@ type User struct {
 	Name     string `json:"name" gorm:"default:anonymous"`
 	Age      int    `json:"age" gorm:"default:18"`
 	LockTest string `json:"lock_test"`
+	Version  int64  `json:"version" gorm:"not null;default:1"` // 乐观锁版本号，每次更新加一
 }
 
 func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		panic(err)
+	}
+
 	// 配置 GORM 日志
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
@@ -40,7 +56,11 @@ func main() {
 	}
 
 	// 自动迁移
-	db.AutoMigrate(&User{})
+	db.AutoMigrate(&User{}, &Job{})
+	// 所有更新都让 version + 1，写 users 不要再手写版本号
+	if err := versioning.Register(db); err != nil {
+		panic(err)
+	}
 
 	// 确保有测试数据
 	var count int64
@@ -55,28 +75,79 @@ func main() {
 		fmt.Println("Created test users")
 	}
 
+	// 读写分离: 配置了 DB_REPLICAS 时读请求走副本；加锁的查询都在事务里，始终在主库
+	replicaCfg, err := replica.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	resolver, err := replica.Register(db, replicaCfg)
+	if err != nil {
+		panic(err)
+	}
+
 	r := gin.Default()
+	apierror.Install(r)
+	// 写入之后 DB_READ_YOUR_WRITES 时长内，同一个客户端的读请求走主库
+	r.Use(resolver.ReadYourWrites())
+
+	// 副本的健康状态和复制延迟
+	r.GET("/replicas", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"policy": resolver.Policy(), "replicas": resolver.Statuses()})
+	})
 
-	// 获取所有用户
+	// 获取用户列表，过滤、排序、分页的写法见 listquery 包，如 ?version[gt]=1&sort=-updated_at&per_page=50
+	// 加上 ?cursor= 改为游标分页，?fields=name,version 只返回部分字段
 	r.GET("/users", func(c *gin.Context) {
-		var users []User
-		db.Find(&users)
-		c.JSON(http.StatusOK, users)
+		users := []User{}
+		q, err := listquery.Find(c, db, &users, listquery.Options{})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, q.Project(c.Request.Context(), users))
 	})
 
-	// 模拟写锁持有一段时间 - 主要测试接口
+	// 模拟行锁持有一段时间 - 主要测试接口
+	// 可选查询参数: ?mode=wait|nowait|skip_locked|timeout&strength=update|no_key_update|share|key_share&timeout=3s
+	// 以及 ?isolation=read_committed|repeatable_read|serializable&read_only=true&deferrable=true
 	r.PUT("/users/lock/:latency/:id", func(c *gin.Context) {
 		id := c.Param("id")
 		latencyStr := c.Param("latency")
-		latency, _ := strconv.Atoi(latencyStr)
+		latency, err := strconv.Atoi(latencyStr)
+		if err != nil || latency < 0 || time.Duration(latency)*time.Second > cfg.MaxLatency {
+			apierror.Respond(c, apierror.BadRequest("latency 必须是 0 到 %d 之间的整数秒", int(cfg.MaxLatency/time.Second)))
+			return
+		}
+
+		mode, err := parseLockMode(c)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("%v", err))
+			return
+		}
+		txSettings, err := parseTxSettings(c)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("%v", err))
+			return
+		}
 
 		// 记录锁开始时间
 		startTime := time.Now()
 
-		fmt.Println("尝试获取写锁, ID:", id)
+		fmt.Printf("尝试获取行锁, ID: %s, 方式: %s\n", id, mode)
 
-		// 简单地开启事务
-		tx := db.Begin()
+		// 客户端断开或超过服务端时限时，ctx 会被取消，事务随之回滚、行锁随之释放
+		ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		// 按请求的隔离级别开启事务
+		tx, err := beginTx(db.WithContext(ctx), txSettings)
+		if err != nil {
+			if respondCanceled(c, ctx, id) {
+				return
+			}
+			apierror.Respond(c, apierror.Wrap(err, "无法开启事务"))
+			return
+		}
 
 		defer func() {
 			if r := recover(); r != nil {
@@ -85,43 +156,48 @@ func main() {
 			}
 		}()
 
-		// 获取写锁 - 关键是使用tx变量和FOR UPDATE
-		var user User
-		if err := tx.Raw("SELECT * FROM users WHERE id = ? FOR UPDATE", id).Scan(&user).Error; err != nil {
+		// 获取行锁 - 关键是使用tx变量和 clause.Locking
+		user, err := lockUser(tx, id, mode)
+		if err != nil {
 			tx.Rollback()
+			if respondCanceled(c, ctx, id) || respondLockError(c, db.WithContext(ctx), id, mode, err) {
+				return
+			}
 			fmt.Printf("无法获取锁或记录不存在, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "无法获取记录或加锁",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "无法获取记录或加锁"))
 			return
 		}
 
-		fmt.Printf("写锁已获取，将持有 %d 秒, ID: %s\n", latency, id)
+		acquiredAt := time.Now()
+		fmt.Printf("行锁已获取，将持有 %d 秒, ID: %s\n", latency, id)
 
-		// 模拟长时间操作
-		time.Sleep(time.Duration(latency) * time.Second)
+		// 模拟长时间操作，期间请求被取消会立即回滚
+		if err := sleepContext(ctx, time.Duration(latency)*time.Second); err != nil {
+			tx.Rollback()
+			respondCanceled(c, ctx, id)
+			return
+		}
 
 		// 更新数据
 		currentTime := time.Now().Format(time.RFC3339)
-		if err := tx.Exec("UPDATE users SET lock_test = ? WHERE id = ?",
-			fmt.Sprintf("Updated at %s after %ds lock", currentTime, latency), id).Error; err != nil {
+		if err := tx.Model(&User{}).Where("id = ?", id).
+			Update("lock_test", fmt.Sprintf("Updated at %s after %ds lock", currentTime, latency)).Error; err != nil {
 			tx.Rollback()
+			if respondCanceled(c, ctx, id) {
+				return
+			}
 			fmt.Printf("更新失败, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "更新失败",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "更新失败"))
 			return
 		}
 
 		// 提交事务
 		if err := tx.Commit().Error; err != nil {
+			if respondCanceled(c, ctx, id) {
+				return
+			}
 			fmt.Printf("提交事务失败, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "提交事务失败",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "提交事务失败"))
 			return
 		}
 
@@ -131,6 +207,10 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{
 			"message":       fmt.Sprintf("成功更新用户 %s", id),
 			"lock_held_for": duration.String(),
+			"started_at":    startTime,
+			"acquired_at":   acquiredAt,
+			"lock_mode":     mode.String(),
+			"isolation":     txSettings.String(),
 			"user":          user,
 		})
 	})
@@ -143,14 +223,14 @@ func main() {
 		fmt.Printf("尝试读取记录, ID: %s\n", id)
 
 		var user User
-		result := db.Raw("SELECT * FROM users WHERE id = ?", id).Scan(&user)
+		result := db.WithContext(c.Request.Context()).Raw("SELECT * FROM users WHERE id = ?", id).Scan(&user)
 		if result.Error != nil || result.RowsAffected == 0 {
 			fmt.Printf("读取失败, ID: %s, 错误: %v\n", id, result.Error)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "读取失败",
-				"details": result.Error,
-				"found":   result.RowsAffected > 0,
-			})
+			if result.Error == nil {
+				apierror.Respond(c, apierror.NotFound("用户 %s 不存在", id))
+				return
+			}
+			apierror.Respond(c, apierror.Wrap(result.Error, "读取失败"))
 			return
 		}
 
@@ -158,22 +238,47 @@ func main() {
 		duration := time.Since(startTime)
 		fmt.Printf("读取完成, ID: %s, 耗时: %v\n", id, duration)
 
+		c.Header("ETag", versionETag(user.Version))
 		c.JSON(http.StatusOK, gin.H{
-			"message":   fmt.Sprintf("读取用户 %s 成功", id),
-			"read_time": duration.String(),
-			"user":      user,
+			"message":     fmt.Sprintf("读取用户 %s 成功", id),
+			"read_time":   duration.String(),
+			"started_at":  startTime,
+			"acquired_at": startTime.Add(duration),
+			"user":        user,
 		})
 	})
 
 	// 尝试快速更新记录 - 演示写锁被写锁阻塞
 	r.PUT("/users/quick-update/:id", func(c *gin.Context) {
 		id := c.Param("id")
+
+		mode, err := parseLockMode(c)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("%v", err))
+			return
+		}
+		txSettings, err := parseTxSettings(c)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("%v", err))
+			return
+		}
+
 		startTime := time.Now()
 
 		fmt.Printf("尝试快速更新, ID: %s\n", id)
 
-		// 简单地开启事务
-		tx := db.Begin()
+		ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		// 按请求的隔离级别开启事务
+		tx, err := beginTx(db.WithContext(ctx), txSettings)
+		if err != nil {
+			if respondCanceled(c, ctx, id) {
+				return
+			}
+			apierror.Respond(c, apierror.Wrap(err, "无法开启事务"))
+			return
+		}
 
 		defer func() {
 			if r := recover(); r != nil {
@@ -182,41 +287,42 @@ func main() {
 			}
 		}()
 
-		// 尝试获取写锁 - 关键是使用FOR UPDATE
-		var user User
-		fmt.Printf("尝试获取写锁用于快速更新, ID: %s\n", id)
-		if err := tx.Raw("SELECT * FROM users WHERE id = ? FOR UPDATE", id).Scan(&user).Error; err != nil {
+		// 尝试获取行锁 - 关键是使用 clause.Locking
+		fmt.Printf("尝试获取行锁用于快速更新, ID: %s, 方式: %s\n", id, mode)
+		user, err := lockUser(tx, id, mode)
+		if err != nil {
 			tx.Rollback()
+			if respondCanceled(c, ctx, id) || respondLockError(c, db.WithContext(ctx), id, mode, err) {
+				return
+			}
 			fmt.Printf("无法获取锁或记录不存在, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "无法获取记录或加锁",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "无法获取记录或加锁"))
 			return
 		}
 
+		acquiredAt := time.Now()
 		fmt.Printf("成功获取写锁用于快速更新, ID: %s\n", id)
 
 		// 快速更新
 		currentTime := time.Now().Format(time.RFC3339)
-		if err := tx.Exec("UPDATE users SET lock_test = ? WHERE id = ?",
-			fmt.Sprintf("Quick updated at %s", currentTime), id).Error; err != nil {
+		if err := tx.Model(&User{}).Where("id = ?", id).
+			Update("lock_test", fmt.Sprintf("Quick updated at %s", currentTime)).Error; err != nil {
 			tx.Rollback()
+			if respondCanceled(c, ctx, id) {
+				return
+			}
 			fmt.Printf("快速更新失败, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "快速更新失败",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "快速更新失败"))
 			return
 		}
 
 		// 提交事务
 		if err := tx.Commit().Error; err != nil {
+			if respondCanceled(c, ctx, id) {
+				return
+			}
 			fmt.Printf("提交事务失败, ID: %s, 错误: %v\n", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "提交事务失败",
-				"details": err,
-			})
+			apierror.Respond(c, apierror.Wrap(err, "提交事务失败"))
 			return
 		}
 
@@ -226,9 +332,472 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{
 			"message":        fmt.Sprintf("快速更新用户 %s 成功", id),
 			"operation_time": duration.String(),
+			"started_at":     startTime,
+			"acquired_at":    acquiredAt,
+			"lock_mode":      mode.String(),
+			"isolation":      txSettings.String(),
 			"user":           user,
 		})
 	})
 
+	// 乐观锁更新: 不加行锁，客户端通过 If-Match 头或请求体里的 version 指明基于哪个版本修改
+	// 版本已过期返回 412，未提供版本返回 428
+	r.PUT("/users/optimistic-update/:id", func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			LockTest *string `json:"lock_test"`
+			Age      *int    `json:"age"`
+			Version  *int64  `json:"version"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var expected int64
+		switch {
+		case c.GetHeader("If-Match") != "":
+			v, err := parseIfMatch(c.GetHeader("If-Match"))
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("无效的 If-Match 头"))
+				return
+			}
+			expected = v
+		case req.Version != nil:
+			expected = *req.Version
+		default:
+			apierror.Respond(c, apierror.New(http.StatusPreconditionRequired, "precondition_required", "需要 If-Match 头或 version 字段"))
+			return
+		}
+
+		values := map[string]interface{}{}
+		if req.LockTest != nil {
+			values["lock_test"] = *req.LockTest
+		}
+		if req.Age != nil {
+			values["age"] = *req.Age
+		}
+
+		user, err := updateWithVersion(db.WithContext(c.Request.Context()), id, expected, values)
+		var conflict *VersionConflictError
+		switch {
+		case errors.As(err, &conflict):
+			fmt.Printf("乐观锁冲突, ID: %s, 期望版本: %d, 当前版本: %d\n", id, conflict.Expected, conflict.Current)
+			c.Header("ETag", versionETag(conflict.Current))
+			apierror.Respond(c, apierror.New(http.StatusPreconditionFailed, "version_conflict", "版本已过期，请重新读取后再修改").
+				WithDetails(conflict))
+			return
+		case err != nil:
+			apierror.Respond(c, apierror.Wrap(err, "更新失败"))
+			return
+		}
+
+		c.Header("ETag", versionETag(user.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("乐观锁更新用户 %s 成功", id),
+			"user":    user,
+		})
+	})
+
+	// 用同样的争用负载对比悲观锁和乐观锁的吞吐量和失败率
+	// 可选查询参数: ?mode=pessimistic|optimistic|both&id=1&workers=8&ops=20&retries=3
+	r.POST("/users/contention", func(c *gin.Context) {
+		id := c.DefaultQuery("id", "1")
+		mode := c.DefaultQuery("mode", "both")
+
+		params := map[string]int{"workers": 8, "ops": 20, "retries": 3}
+		for name := range params {
+			if s := c.Query(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 || (name != "retries" && n == 0) {
+					apierror.Respond(c, apierror.BadRequest("无效的 %s: %q", name, s))
+					return
+				}
+				params[name] = n
+			}
+		}
+
+		var modes []string
+		switch mode {
+		case "pessimistic", "optimistic":
+			modes = []string{mode}
+		case "both":
+			modes = []string{"pessimistic", "optimistic"}
+		default:
+			apierror.Respond(c, apierror.BadRequest("未知的 mode: %q", mode))
+			return
+		}
+
+		var reports []ContentionReport
+		for _, m := range modes {
+			report := runContention(db.WithContext(c.Request.Context()), m, id, params["workers"], params["ops"], params["retries"])
+			fmt.Printf("争用测试 %s: 成功 %d, 失败 %d, 冲突 %d, 耗时 %s, 吞吐 %.1f ops/s\n",
+				m, report.Succeeded, report.Failed, report.Conflicts, report.Elapsed, report.Throughput)
+			reports = append(reports, report)
+		}
+		c.JSON(http.StatusOK, reports)
+	})
+
+	// 故意制造死锁: 两个事务以相反的顺序更新 id1、id2，验证死锁后的自动重试
+	// 可选查询参数: ?max_attempts=5
+	r.PUT("/users/deadlock/:id1/:id2", func(c *gin.Context) {
+		id1, id2 := c.Param("id1"), c.Param("id2")
+		if id1 == id2 {
+			apierror.Respond(c, apierror.BadRequest("id1 和 id2 不能相同"))
+			return
+		}
+
+		policy := txretry.DefaultPolicy
+		policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+			fmt.Printf("事务第 %d 次执行失败，%v 后重试: %v\n", attempt, delay, err)
+		}
+		if s := c.Query("max_attempts"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				apierror.Respond(c, apierror.BadRequest("max_attempts 必须是正整数"))
+				return
+			}
+			policy.MaxAttempts = n
+		}
+
+		type result struct {
+			Order  string         `json:"order"`
+			Report txretry.Report `json:"report"`
+			Error  string         `json:"error,omitempty"`
+		}
+
+		readyA, readyB := make(chan struct{}), make(chan struct{})
+		results := make([]result, 2)
+		var wg sync.WaitGroup
+		run := func(i int, first, second string, ready chan<- struct{}, peerReady <-chan struct{}) {
+			defer wg.Done()
+			report, err := lockInOrder(db.WithContext(c.Request.Context()), policy, first, second, ready, peerReady)
+			results[i] = result{Order: first + " -> " + second, Report: report}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}
+
+		startTime := time.Now()
+		wg.Add(2)
+		go run(0, id1, id2, readyA, readyB)
+		go run(1, id2, id1, readyB, readyA)
+		wg.Wait()
+
+		status := http.StatusOK
+		for _, res := range results {
+			if res.Error != "" {
+				status = http.StatusConflict
+			}
+		}
+		c.JSON(status, gin.H{
+			"message":      "死锁演示完成",
+			"elapsed":      time.Since(startTime).String(),
+			"transactions": results,
+		})
+	})
+
+	// 在各个隔离级别下运行丢失更新、不可重复读、写偏斜场景
+	// 可选查询参数: ?scenario=lost_update,non_repeatable_read,write_skew&isolation=read_committed,serializable
+	r.POST("/scenarios/anomalies", func(c *gin.Context) {
+		var names, levels []string
+		if s := c.Query("scenario"); s != "" {
+			names = strings.Split(s, ",")
+		}
+		if s := c.Query("isolation"); s != "" {
+			levels = strings.Split(s, ",")
+		}
+
+		results, err := runAnomalyScenarios(db.WithContext(c.Request.Context()), names, levels)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("%v", err))
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	})
+
+	// 锁等待检查器使用单独的会话，避免每次轮询都打印 SQL 日志
+	// pg_locks、pg_stat_activity 和 advisory lock 只存在于主库，固定走主库
+	inspectDB := replica.Primary(db).Session(&gorm.Session{Logger: newLogger.LogMode(logger.Warn)})
+
+	// 查看 users 表上当前的锁持有者和等待者
+	r.GET("/locks", func(c *gin.Context) {
+		snapshot, err := lockSnapshot(inspectDB.WithContext(c.Request.Context()))
+		if err != nil {
+			apierror.Respond(c, apierror.Wrap(err, "查询锁信息失败"))
+			return
+		}
+		c.JSON(http.StatusOK, snapshot)
+	})
+
+	// 以 SSE 的方式持续推送锁信息，?interval=1s 控制推送间隔
+	r.GET("/locks/stream", func(c *gin.Context) {
+		interval := time.Second
+		if s := c.Query("interval"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 100*time.Millisecond {
+				apierror.Respond(c, apierror.BadRequest("interval 至少为 100ms"))
+				return
+			}
+			interval = d
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		first := true
+		c.Stream(func(w io.Writer) bool {
+			if !first {
+				select {
+				case <-c.Request.Context().Done():
+					return false
+				case <-ticker.C:
+				}
+			}
+			first = false
+
+			snapshot, err := lockSnapshot(inspectDB.WithContext(c.Request.Context()))
+			if err != nil {
+				if c.Request.Context().Err() != nil {
+					return false
+				}
+				c.SSEvent("error", apierror.From(err).Response)
+				return false
+			}
+			c.SSEvent("locks", snapshot)
+			return true
+		})
+	})
+
+	// 基于 advisory lock 的分布式互斥锁，不需要锁住 users 这样的业务行
+	locker := NewAdvisoryLocker(inspectDB, 2*time.Second)
+
+	parseDurationQuery := func(c *gin.Context, name string, def time.Duration) (time.Duration, bool) {
+		s := c.Query(name)
+		if s == "" {
+			return def, true
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			apierror.Respond(c, apierror.BadRequest("无效的 %s: %q", name, s))
+			return 0, false
+		}
+		return d, true
+	}
+
+	// 租约时长必须大于 0，否则拿到锁之后的第一次心跳就会释放
+	parseTTL := func(c *gin.Context) (time.Duration, bool) {
+		ttl, ok := parseDurationQuery(c, "ttl", 30*time.Second)
+		if ok && ttl <= 0 {
+			apierror.Respond(c, apierror.BadRequest("ttl 必须大于 0: %q", c.Query("ttl")))
+			return 0, false
+		}
+		return ttl, ok
+	}
+
+	respondLeaseError := func(c *gin.Context, err error) {
+		switch {
+		case errors.Is(err, ErrLeaseNotFound):
+			apierror.Respond(c, apierror.NotFound("%v", err))
+		case errors.Is(err, ErrLeaseLost):
+			apierror.Respond(c, apierror.New(http.StatusGone, "lease_lost", err.Error()))
+		default:
+			apierror.Respond(c, err)
+		}
+	}
+
+	// 查看本进程持有的租约，以及数据库里所有会话的 advisory lock
+	r.GET("/advisory", func(c *gin.Context) {
+		locks, err := advisoryLocks(inspectDB.WithContext(c.Request.Context()), 0)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"leases": locker.Leases(),
+			"locks":  locks,
+		})
+	})
+
+	// 获取锁并返回租约，?ttl=30s 租约时长，?wait=0s 拿不到锁时最多等待多久
+	r.POST("/advisory/:name/acquire", func(c *gin.Context) {
+		name := c.Param("name")
+		ttl, ok := parseTTL(c)
+		if !ok {
+			return
+		}
+		wait, ok := parseDurationQuery(c, "wait", 0)
+		if !ok {
+			return
+		}
+		if wait > cfg.RequestTimeout {
+			wait = cfg.RequestTimeout
+		}
+
+		lease, err := locker.Acquire(c.Request.Context(), name, ttl, wait)
+		if errors.Is(err, ErrAdvisoryLockHeld) {
+			holders, _ := advisoryLocks(inspectDB.WithContext(c.Request.Context()), advisoryKey(name))
+			apierror.Respond(c, apierror.New(http.StatusLocked, apierror.CodeLocked, "锁已被其他会话持有").
+				WithDetails(gin.H{"name": name, "key": advisoryKey(name), "holders": holders}))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, lease.snapshot())
+	})
+
+	// 续约
+	r.POST("/advisory/leases/:token/renew", func(c *gin.Context) {
+		ttl, ok := parseTTL(c)
+		if !ok {
+			return
+		}
+		lease, err := locker.Renew(c.Param("token"), ttl)
+		if err != nil {
+			respondLeaseError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, lease)
+	})
+
+	// 释放锁
+	r.DELETE("/advisory/leases/:token", func(c *gin.Context) {
+		if err := locker.Release(c.Param("token")); err != nil {
+			respondLeaseError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// 事务级 advisory lock: 在事务里持有 name 锁 latency 秒，事务结束自动释放
+	r.POST("/advisory/:name/critical/:latency", func(c *gin.Context) {
+		name := c.Param("name")
+		latency, err := strconv.Atoi(c.Param("latency"))
+		if err != nil || latency < 0 || time.Duration(latency)*time.Second > cfg.MaxLatency {
+			apierror.Respond(c, apierror.BadRequest("latency 必须是 0 到 %d 之间的整数秒", int(cfg.MaxLatency/time.Second)))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		startTime := time.Now()
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			ok, err := tryXactLock(tx, name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrAdvisoryLockHeld
+			}
+			fmt.Printf("事务级 advisory lock 已获取: %s, 将持有 %d 秒\n", name, latency)
+			return sleepContext(ctx, time.Duration(latency)*time.Second)
+		})
+		switch {
+		case errors.Is(err, ErrAdvisoryLockHeld):
+			holders, _ := advisoryLocks(inspectDB.WithContext(c.Request.Context()), advisoryKey(name))
+			apierror.Respond(c, apierror.New(http.StatusLocked, apierror.CodeLocked, "锁已被其他会话持有").
+				WithDetails(gin.H{"name": name, "holders": holders}))
+		case respondCanceled(c, ctx, name):
+		case err != nil:
+			apierror.Respond(c, err)
+		default:
+			c.JSON(http.StatusOK, gin.H{
+				"message":       fmt.Sprintf("临界区 %s 执行完成", name),
+				"lock_held_for": time.Since(startTime).String(),
+			})
+		}
+	})
+
+	// 任务队列: worker 用 FOR UPDATE SKIP LOCKED 并发领取任务
+	jobQueue := NewJobQueue(inspectDB, cfg.JobVisibilityTimeout)
+	registerDemoJobHandlers(jobQueue)
+	if cfg.JobWorkers > 0 {
+		jobQueue.StartWorkers(context.Background(), "default", cfg.JobWorkers, 5)
+	}
+
+	// 入队
+	r.POST("/jobs", func(c *gin.Context) {
+		var req EnqueueRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		job, err := jobQueue.Enqueue(c.Request.Context(), req)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, job)
+	})
+
+	// 查看任务，?status=dead&queue=default&limit=50
+	r.GET("/jobs", func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 500 {
+			apierror.Respond(c, apierror.BadRequest("limit 必须在 1 到 500 之间"))
+			return
+		}
+		query := db.WithContext(c.Request.Context()).Order("id DESC").Limit(limit)
+		if s := c.Query("status"); s != "" {
+			query = query.Where("status = ?", s)
+		}
+		if s := c.Query("queue"); s != "" {
+			query = query.Where("queue = ?", s)
+		}
+		jobs := []Job{}
+		if err := query.Find(&jobs).Error; err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, jobs)
+	})
+
+	// 每个队列每种状态的任务数
+	r.GET("/jobs/stats", func(c *gin.Context) {
+		stats, err := jobQueue.Stats(c.Request.Context())
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	r.GET("/jobs/:id", func(c *gin.Context) {
+		var job Job
+		if err := db.WithContext(c.Request.Context()).Where("id = ?", c.Param("id")).Take(&job).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apierror.Respond(c, apierror.NotFound("任务不存在"))
+				return
+			}
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	})
+
+	// 重新入队，主要用于死信任务
+	r.POST("/jobs/:id/requeue", func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("无效的任务 ID"))
+			return
+		}
+		job, err := jobQueue.Requeue(c.Request.Context(), uint(id))
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			apierror.Respond(c, apierror.NotFound("任务不存在"))
+		case errors.Is(err, ErrJobRunning):
+			apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeConflict, err.Error()))
+		case err != nil:
+			apierror.Respond(c, err)
+		default:
+			c.JSON(http.StatusOK, job)
+		}
+	})
+
 	r.Run(":8080")
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm-shared/replica"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrVersionConflict 乐观锁版本不匹配，可以用 errors.Is 判断
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionConflictError 客户端提交的版本号已经过期
+type VersionConflictError struct {
+	ID       string `json:"id"`
+	Expected int64  `json:"expected"`
+	Current  int64  `json:"current"`
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("user %s: version %d is stale, current version is %d", e.ID, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// versionETag 用版本号生成强 ETag
+func versionETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseIfMatch 解析 If-Match 头里的版本号，支持 "3" 和 W/"3"
+func parseIfMatch(header string) (int64, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	v = strings.Trim(v, `"`)
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// updateWithVersion 只有当数据库里的版本号仍然等于 expected 时才更新，版本号由 versioning 回调加一
+// 版本号不匹配返回 *VersionConflictError，记录不存在返回 gorm.ErrRecordNotFound
+func updateWithVersion(db *gorm.DB, id string, expected int64, values map[string]interface{}) (User, error) {
+	var user User
+	result := db.Model(&user).Clauses(clause.Returning{}).
+		Where("id = ? AND version = ?", id, expected).
+		Updates(values)
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return user, nil
+	}
+
+	// 没有更新到任何行：要么记录不存在，要么版本已经被别人改过
+	// 必须读主库，副本可能还没看到刚才让更新失败的那次写入
+	var current User
+	if err := replica.Primary(db).Select("id", "version").Where("id = ?", id).Take(&current).Error; err != nil {
+		return user, err
+	}
+	return user, &VersionConflictError{ID: id, Expected: expected, Current: current.Version}
+}
+
+// ContentionReport 一种并发控制方式在同一负载下的表现
+type ContentionReport struct {
+	Mode       string  `json:"mode"`
+	Workers    int     `json:"workers"`
+	Operations int     `json:"operations"` // 计划执行的操作数
+	Succeeded  int64   `json:"succeeded"`
+	Failed     int64   `json:"failed"`    // 重试用尽后仍然失败的操作
+	Conflicts  int64   `json:"conflicts"` // 乐观锁遇到的版本冲突次数 (含重试)
+	FailRate   float64 `json:"fail_rate"`
+	Elapsed    string  `json:"elapsed"`
+	Throughput float64 `json:"throughput"` // 每秒成功的操作数
+}
+
+// runContention workers 个协程各自对同一行执行 ops 次 "读取 age，加一，写回"
+// pessimistic 用 SELECT ... FOR UPDATE 排队，optimistic 不加锁，靠版本号发现冲突后重读重试
+func runContention(db *gorm.DB, mode string, id string, workers, ops, retries int) ContentionReport {
+	report := ContentionReport{Mode: mode, Workers: workers, Operations: workers * ops}
+
+	pessimistic := func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var user User
+			if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).Where("id = ?", id).Take(&user).Error; err != nil {
+				return err
+			}
+			return tx.Model(&User{}).Where("id = ?", id).Update("age", user.Age+1).Error
+		})
+	}
+
+	// 读取-修改-写回的读也走主库，从落后的副本读到旧版本号会被算成冲突
+	primary := replica.Primary(db)
+	optimistic := func() error {
+		var err error
+		for attempt := 0; attempt <= retries; attempt++ {
+			var user User
+			if err = primary.Where("id = ?", id).Take(&user).Error; err != nil {
+				return err
+			}
+			_, err = updateWithVersion(db, id, user.Version, map[string]interface{}{"age": user.Age + 1})
+			if !errors.Is(err, ErrVersionConflict) {
+				return err
+			}
+			atomic.AddInt64(&report.Conflicts, 1)
+		}
+		return err
+	}
+
+	op := pessimistic
+	if mode == "optimistic" {
+		op = optimistic
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				if err := op(); err != nil {
+					atomic.AddInt64(&report.Failed, 1)
+				} else {
+					atomic.AddInt64(&report.Succeeded, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	report.Elapsed = elapsed.String()
+	report.Throughput = float64(report.Succeeded) / elapsed.Seconds()
+	if report.Operations > 0 {
+		report.FailRate = float64(report.Failed) / float64(report.Operations)
+	}
+	return report
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm-shared/txretry"
+	"gorm.io/gorm"
+)
+
+// lockInOrder 在一个事务里依次更新 first、second 两行
+// 第一次执行时拿到 first 的锁后等对方也拿到锁再继续，这样两个方向相反的调用一定会死锁；
+// 重试时不再等待，由 Postgres 选中的牺牲者在对方提交后重新执行
+func lockInOrder(db *gorm.DB, policy txretry.Policy, first, second string, ready chan<- struct{}, peerReady <-chan struct{}) (txretry.Report, error) {
+	attempt := 0
+	return txretry.Run(db, policy, func(tx *gorm.DB) error {
+		attempt++
+		note := fmt.Sprintf("deadlock demo %s -> %s at %s", first, second, time.Now().Format(time.RFC3339Nano))
+
+		if err := tx.Model(&User{}).Where("id = ?", first).Update("lock_test", note).Error; err != nil {
+			return err
+		}
+
+		if attempt == 1 {
+			close(ready)
+			select {
+			case <-peerReady:
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		return tx.Model(&User{}).Where("id = ?", second).Update("lock_test", note).Error
+	})
+}
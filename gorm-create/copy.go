@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm-shared/datetypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// CopyReport 一次 COPY 导入的结果
+type CopyReport struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+	Elapsed string   `json:"elapsed"`
+}
+
+// copyFrom 用 COPY FROM STDIN 批量写入 values (结构体切片或结构体指针切片的指针)
+//
+// 列从模型的 gorm schema 推导，和 Create 的行为保持一致：
+//   - 自增主键先用 nextval 批量预分配，写入后回填到结构体
+//   - CreatedAt / UpdatedAt 为零值时填当前时间
+//   - 零值字段使用 default 标签里的默认值；默认值是 SQL 表达式的列，要么每行都赋值，要么整列交给数据库
+//
+// COPY 只有一条语句，不受 65535 个绑定参数的限制。整个过程在一个事务里完成，不能在 gorm 事务中调用。
+func copyFrom(db *gorm.DB, values interface{}) (CopyReport, error) {
+	start := time.Now()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(values); err != nil {
+		return CopyReport{}, err
+	}
+	sch := stmt.Schema
+	report := CopyReport{Table: sch.Table}
+
+	rv := reflect.Indirect(reflect.ValueOf(values))
+	if rv.Kind() != reflect.Slice {
+		return report, fmt.Errorf("copyFrom: values must be a pointer to a slice, got %T", values)
+	}
+	n := rv.Len()
+	if n == 0 {
+		return report, nil
+	}
+	rows := make([]reflect.Value, n)
+	for i := range rows {
+		rows[i] = reflect.Indirect(rv.Index(i))
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// 自增主键：只给 ID 为零的行预分配
+	pk := sch.PrioritizedPrimaryField
+	var needIDs []int
+	if pk != nil && pk.AutoIncrement {
+		for i, row := range rows {
+			if _, zero := pk.ValueOf(ctx, row); zero {
+				needIDs = append(needIDs, i)
+			}
+		}
+	}
+
+	// 时间戳和标签默认值
+	now := time.Now()
+	var columns []*schema.Field
+	for _, field := range sch.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		if field == pk && pk.AutoIncrement {
+			columns = append(columns, field)
+			continue
+		}
+
+		zeroRows := 0
+		for _, row := range rows {
+			if _, zero := field.ValueOf(ctx, row); zero {
+				zeroRows++
+				if field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+					if err := field.Set(ctx, row, now); err != nil {
+						return report, err
+					}
+				} else if field.HasDefaultValue && field.DefaultValueInterface != nil {
+					if err := field.Set(ctx, row, field.DefaultValueInterface); err != nil {
+						return report, err
+					}
+				}
+			}
+		}
+
+		if field.HasDefaultValue && field.DefaultValueInterface == nil && field.AutoCreateTime == 0 && field.AutoUpdateTime == 0 {
+			// 默认值是数据库表达式 (如 now())，COPY 时列一旦出现就不会再使用默认值
+			switch zeroRows {
+			case n:
+				continue
+			case 0:
+			default:
+				return report, fmt.Errorf("copyFrom: column %s has a database default expression %q, set it on every row or on none", field.DBName, field.DefaultValue)
+			}
+		}
+		columns = append(columns, field)
+	}
+
+	names := make([]string, len(columns))
+	for i, field := range columns {
+		names[i] = field.DBName
+	}
+	report.Columns = names
+
+	err := db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		sqlConn, ok := conn.Statement.ConnPool.(*sql.Conn)
+		if !ok {
+			return errors.New("copyFrom: expected a dedicated *sql.Conn")
+		}
+		return sqlConn.Raw(func(driverConn interface{}) error {
+			pgConn := driverConn.(*stdlib.Conn).Conn()
+			tx, err := pgConn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(ctx)
+
+			if len(needIDs) > 0 {
+				ids, err := nextIDs(ctx, tx, sch.Table, pk.DBName, len(needIDs))
+				if err != nil {
+					return err
+				}
+				for j, i := range needIDs {
+					if err := pk.Set(ctx, rows[i], ids[j]); err != nil {
+						return err
+					}
+				}
+			}
+
+			i := 0
+			src := pgx.CopyFromFunc(func() ([]any, error) {
+				if i >= n {
+					return nil, nil
+				}
+				values := make([]any, len(columns))
+				for c, field := range columns {
+					values[c] = copyValue(field.ReflectValueOf(ctx, rows[i]).Interface())
+				}
+				i++
+				return values, nil
+			})
+			if report.Rows, err = tx.CopyFrom(ctx, pgx.Identifier{sch.Table}, names, src); err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+	})
+	if err != nil && len(needIDs) > 0 {
+		// 事务已回滚，预分配的 ID 作废
+		for _, i := range needIDs {
+			pk.Set(ctx, rows[i], 0)
+		}
+	}
+	report.Elapsed = time.Since(start).String()
+	return report, err
+}
+
+// nextIDs 从主键序列一次取 count 个值
+func nextIDs(ctx context.Context, tx pgx.Tx, table, column string, count int) ([]int64, error) {
+	rows, err := tx.Query(ctx, "SELECT nextval(pg_get_serial_sequence($1, $2)) FROM generate_series(1, $3)", table, column, count)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// copyValue 把 gorm 的值转成 pgx 能用二进制格式编码的值
+// Date 的 Value() 返回字符串，pgx 只能先按文本解析再编码，直接给 time.Time 更快
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case datetypes.Date:
+		return v.Time()
+	case gorm.DeletedAt:
+		if !v.Valid {
+			return nil
+		}
+		return v.Time
+	}
+	return v
+}
+
+// runBenchCommand 对比 CreateInBatches 和 COPY: go run . bench [-n 100000] [-batch 100]
+func runBenchCommand(db *gorm.DB, args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := fs.Int("n", 100000, "rows per run")
+	batchSize := fs.Int("batch", 100, "batch size for CreateInBatches, same as /users/batch/in-batches")
+	keep := fs.Bool("keep", false, "keep inserted rows instead of deleting them")
+	fs.Parse(args)
+	if *n <= 0 || *batchSize <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	prefix := fmt.Sprintf("bench-%d", time.Now().UnixNano())
+	generate := func(method string) []*User {
+		users := make([]*User, *n)
+		for i := range users {
+			users[i] = &User{
+				Name:     fmt.Sprintf("%s-%s-%d", prefix, method, i),
+				Age:      i%80 + 1,
+				Birthday: datetypes.Today().AddDays(-i % 36500),
+			}
+		}
+		return users
+	}
+
+	type result struct {
+		method  string
+		elapsed time.Duration
+		err     error
+	}
+	var results []result
+
+	users := generate("in-batches")
+	start := time.Now()
+	err := db.CreateInBatches(users, *batchSize).Error
+	results = append(results, result{"CreateInBatches", time.Since(start), err})
+
+	users = generate("copy")
+	start = time.Now()
+	_, err = copyFrom(db, &users)
+	results = append(results, result{"COPY", time.Since(start), err})
+
+	fmt.Printf("\n插入 %d 行 (CreateInBatches 每批 %d 行)\n", *n, *batchSize)
+	fmt.Printf("%-16s %14s %14s  %s\n", "方式", "耗时", "行/秒", "错误")
+	fmt.Println(strings.Repeat("-", 60))
+	failed := false
+	for _, r := range results {
+		errMsg := ""
+		if r.err != nil {
+			errMsg = r.err.Error()
+			failed = true
+		}
+		fmt.Printf("%-16s %14s %14.0f  %s\n", r.method, r.elapsed.Round(time.Millisecond), float64(*n)/r.elapsed.Seconds(), errMsg)
+	}
+
+	if !*keep {
+		result := db.Unscoped().Where("name LIKE ?", prefix+"-%").Delete(&User{})
+		if result.Error != nil {
+			fmt.Fprintf(os.Stderr, "清理测试数据失败: %v\n", result.Error)
+		} else {
+			fmt.Printf("已清理 %d 行测试数据\n", result.RowsAffected)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyTxKey  = "idempotency_tx"
+	// idempotencySavepoint 业务逻辑开始前的 savepoint
+	idempotencySavepoint = "idempotency_handler"
+)
+
+// IdempotencyKey 已处理过的请求，重复请求直接返回这里保存的响应
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey;size:255"`
+	Method      string `gorm:"not null"`
+	Path        string `gorm:"not null"`
+	RequestHash string `gorm:"not null"`
+	Status      int    `gorm:"not null"`
+	ContentType string `gorm:"not null;default:''"`
+	Body        []byte `gorm:"type:bytea"`
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+// txFrom 返回幂等中间件开启的事务，没有 Idempotency-Key 时返回 db 本身
+// 业务写入和幂等记录在同一个事务里提交，要么都生效，要么都不生效
+func txFrom(c *gin.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := c.Get(idempotencyTxKey); ok {
+		return tx.(*gorm.DB)
+	}
+	return db.WithContext(c.Request.Context())
+}
+
+// bufferedWriter 先把响应缓存起来，事务提交成功后再真正发给客户端
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+// flush 把缓存的响应写给客户端
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+func requestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotency 处理带 Idempotency-Key 头的请求
+//
+// 第一次请求先在事务里插入幂等记录，再执行业务逻辑，最后把响应写回记录并一起提交；
+// 并发的同 key 请求会阻塞在主键冲突上，等前一个事务结束后直接重放它的响应。
+// 4xx 响应会撤销业务逻辑的写入，只保存响应；5xx 响应会回滚整个事务，客户端可以用同一个 key 重试。
+func idempotency(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			apierror.Respond(c, apierror.BadRequest("Idempotency-Key 不能超过 255 个字符"))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("读取请求体失败: %v", err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := IdempotencyKey{
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			RequestHash: requestHash(c.Request.Method, c.FullPath(), body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+
+		tx := db.WithContext(c.Request.Context()).Begin()
+		if tx.Error != nil {
+			apierror.Respond(c, tx.Error)
+			return
+		}
+
+		// 已过期的 key 当作新 key 重新占用
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"method", "path", "request_hash", "status", "content_type", "body", "created_at", "expires_at"}),
+			Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "idempotency_keys.expires_at < now()"}}},
+		}).Create(&record)
+		if result.Error != nil {
+			tx.Rollback()
+			apierror.Respond(c, result.Error)
+			return
+		}
+
+		if result.RowsAffected == 0 {
+			// key 已被使用过，重放保存的响应
+			var stored IdempotencyKey
+			err := tx.Where("key = ?", key).Take(&stored).Error
+			tx.Rollback()
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			if stored.RequestHash != record.RequestHash {
+				apierror.Respond(c, apierror.New(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key 已用于另一个不同的请求").
+					WithDetails(gin.H{"key": key, "method": stored.Method, "path": stored.Path, "first_used_at": stored.CreatedAt}))
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		// 业务逻辑在 savepoint 里执行：它的 SQL 出错 (比如唯一约束冲突返回 409、非空约束返回 400) 时
+		// Postgres 会中止整个事务，回滚到 savepoint 之后才能继续保存幂等记录
+		if err := tx.SavePoint(idempotencySavepoint).Error; err != nil {
+			tx.Rollback()
+			apierror.Respond(c, err)
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Set(idempotencyTxKey, tx)
+		defer func() {
+			// handler panic 时回滚，再交给 gin.Recovery 处理
+			if r := recover(); r != nil {
+				tx.Rollback()
+				c.Writer = writer.ResponseWriter
+				panic(r)
+			}
+		}()
+
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.status >= http.StatusInternalServerError {
+			tx.Rollback()
+			writer.flush()
+			return
+		}
+
+		// 4xx 表示请求失败，撤销业务逻辑里已经执行的写入，只保存响应
+		if writer.status >= http.StatusBadRequest {
+			err = tx.RollbackTo(idempotencySavepoint).Error
+		}
+		if err == nil {
+			err = tx.Model(&record).Updates(map[string]interface{}{
+				"status":       writer.status,
+				"content_type": writer.Header().Get("Content-Type"),
+				"body":         writer.body.Bytes(),
+			}).Error
+		}
+		if err == nil {
+			err = tx.Commit().Error
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "保存幂等记录失败，请求已回滚").WithDetails(err.Error()))
+			return
+		}
+		writer.flush()
+	}
+}
+
+// cleanupIdempotencyKeys 每隔 interval 删除一次过期的幂等记录，ctx 取消后退出
+func cleanupIdempotencyKeys(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result := db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{})
+			if result.Error != nil && !errors.Is(result.Error, context.Canceled) {
+				fmt.Printf("清理过期幂等记录失败: %v\n", result.Error)
+			} else if result.RowsAffected > 0 {
+				fmt.Printf("已清理 %d 条过期幂等记录\n", result.RowsAffected)
+			}
+		}
+	}
+}
+
+// envDuration 读取时长类型的环境变量，未设置或格式错误时使用默认值
+func envDuration(name string, def time.Duration) time.Duration {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		fmt.Printf("环境变量 %s=%q 无效，使用默认值 %s\n", name, s, def)
+		return def
+	}
+	return d
+}
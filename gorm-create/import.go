@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"gorm-shared/batching"
+	"gorm-shared/datetypes"
+	"gorm.io/gorm"
+)
+
+// 导入模式
+const (
+	ImportAbort = "abort" // 任意一行出错就回滚整个导入
+	ImportSkip  = "skip"  // 跳过出错的行，其余照常导入
+)
+
+// 支持的导入格式
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ImportResult 一行的导入结果
+type ImportResult struct {
+	Line  int    `json:"line"`
+	ID    uint   `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportReport 整个导入的结果
+type ImportReport struct {
+	Format    string         `json:"format"`
+	Mode      string         `json:"mode"`
+	Total     int            `json:"total"`
+	Inserted  int            `json:"inserted"`
+	Failed    int            `json:"failed"`
+	Aborted   bool           `json:"aborted"`    // abort 模式下出错回滚，Inserted 为 0
+	BatchSize int            `json:"batch_size"` // 每条 INSERT 的行数
+	Elapsed   string         `json:"elapsed"`
+	Rows      []ImportResult `json:"rows"`
+}
+
+// importRow 读取到的一行，err 不为空表示这一行本身无法解析
+type importRow struct {
+	line int
+	user *User
+	err  error
+}
+
+// rowReader 逐行读取导入数据，读完返回 io.EOF
+type rowReader interface {
+	Next() (importRow, error)
+}
+
+// csvRowReader 读取带表头的 CSV，必须包含 name, age, birthday 三列，顺序不限
+type csvRowReader struct {
+	r    *csv.Reader
+	cols map[string]int
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, name := range []string{"name", "age", "birthday"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %q", name)
+		}
+	}
+	return &csvRowReader{r: cr, cols: cols}, nil
+}
+
+func (r *csvRowReader) Next() (importRow, error) {
+	record, err := r.r.Read()
+	if err == io.EOF {
+		return importRow{}, err
+	}
+	if err != nil {
+		// 引号不匹配之类的错误只影响这一行
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return importRow{line: perr.StartLine, err: err}, nil
+		}
+		return importRow{}, err
+	}
+
+	row := importRow{}
+	row.line, _ = r.r.FieldPos(0)
+	field := func(name string) string {
+		if i := r.cols[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	user := &User{Name: field("name")}
+	if s := field("age"); s != "" {
+		age, err := strconv.Atoi(s)
+		if err != nil {
+			row.err = fmt.Errorf("invalid age %q", s)
+			return row, nil
+		}
+		user.Age = age
+	}
+	if s := field("birthday"); s != "" {
+		date, err := datetypes.ParseDate(s)
+		if err != nil {
+			row.err = fmt.Errorf("invalid birthday %q: %w", s, err)
+			return row, nil
+		}
+		user.Birthday = date
+	}
+	row.user = user
+	return row, nil
+}
+
+// ndjsonRowReader 每行一个 JSON 对象，字段与 POST /users 相同，空行忽略
+type ndjsonRowReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONRowReader(r io.Reader) *ndjsonRowReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonRowReader{s: s}
+}
+
+func (r *ndjsonRowReader) Next() (importRow, error) {
+	for r.s.Scan() {
+		r.line++
+		b := r.s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		row := importRow{line: r.line}
+		var user User
+		if err := json.Unmarshal(b, &user); err != nil {
+			row.err = err
+			return row, nil
+		}
+		// 主键和时间戳由数据库生成，不接受客户端传入
+		user.Model = gorm.Model{}
+		row.user = &user
+		return row, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+func newRowReader(format string, r io.Reader) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVRowReader(r)
+	case FormatNDJSON:
+		return newNDJSONRowReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use csv or ndjson", format)
+	}
+}
+
+// validateImportUser 使用与 ShouldBindJSON 相同的 binding 规则校验
+func validateImportUser(user *User) error {
+	return binding.Validator.ValidateStruct(user)
+}
+
+// importUsers 流式读取 src，每攒够 batchSize 行就用 CreateInBatches 写入一次
+// batchSize 为 0 时按 User 的列数和绑定参数上限计算
+// abort 模式在一个事务里完成，出错整体回滚；skip 模式下插入失败的批次会逐行重试，找出具体出错的行
+func importUsers(db *gorm.DB, src rowReader, format, mode string, batchSize int) (ImportReport, error) {
+	start := time.Now()
+	report := ImportReport{Format: format, Mode: mode, Rows: []ImportResult{}}
+	if batchSize <= 0 {
+		size, err := batching.Size(db, &User{}, batching.Options{})
+		if err != nil {
+			return report, err
+		}
+		batchSize = size
+	}
+	report.BatchSize = batchSize
+
+	run := func(tx *gorm.DB) error {
+		batch := make([]*User, 0, batchSize)
+		index := make([]int, 0, batchSize) // batch 中每个用户在 report.Rows 中的下标
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			err := tx.CreateInBatches(batch, batchSize).Error
+			switch {
+			case err == nil:
+				for i, user := range batch {
+					report.Rows[index[i]].ID = user.ID
+				}
+				report.Inserted += len(batch)
+			case mode == ImportAbort:
+				return err
+			default:
+				for i, user := range batch {
+					if err := tx.Create(user).Error; err != nil {
+						report.Rows[index[i]].Error = err.Error()
+						report.Failed++
+						continue
+					}
+					report.Rows[index[i]].ID = user.ID
+					report.Inserted++
+				}
+			}
+			batch, index = batch[:0], index[:0]
+			return nil
+		}
+
+		for {
+			row, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			report.Total++
+			report.Rows = append(report.Rows, ImportResult{Line: row.line})
+			if row.err == nil {
+				row.err = validateImportUser(row.user)
+			}
+			if row.err != nil {
+				report.Rows[len(report.Rows)-1].Error = row.err.Error()
+				report.Failed++
+				if mode == ImportAbort {
+					return fmt.Errorf("line %d: %w", row.line, row.err)
+				}
+				continue
+			}
+
+			batch = append(batch, row.user)
+			index = append(index, len(report.Rows)-1)
+			if len(batch) >= batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return flush()
+	}
+
+	var err error
+	if mode == ImportAbort {
+		err = db.Transaction(run)
+		if err != nil {
+			// 事务已回滚，之前分配的 ID 都无效了
+			report.Aborted = true
+			report.Inserted = 0
+			for i := range report.Rows {
+				report.Rows[i].ID = 0
+			}
+		}
+	} else {
+		err = run(db)
+	}
+	report.Elapsed = time.Since(start).String()
+	return report, err
+}
+
+// importFormat 根据 ?format= 或 Content-Type 判断格式
+func importFormat(format, contentType string) string {
+	if format != "" {
+		format = strings.ToLower(format)
+		if format == "jsonl" {
+			return FormatNDJSON
+		}
+		return format
+	}
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return FormatCSV
+	case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"):
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// runImportCommand 命令行导入: go run . import [-format csv|ndjson] [-mode abort|skip] [-batch 0] <file|->
+func runImportCommand(db *gorm.DB, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "csv or ndjson, guessed from the file extension when empty")
+	mode := fs.String("mode", ImportAbort, "abort or skip")
+	batchSize := fs.Int("batch", 0, "rows per INSERT, 0 computes it from the column count")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *batchSize < 0 || (*mode != ImportAbort && *mode != ImportSkip) {
+		fmt.Fprintln(os.Stderr, "usage: import [-format csv|ndjson] [-mode abort|skip] [-batch 0] <file|->")
+		return 2
+	}
+
+	path := fs.Arg(0)
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+		if *format == "" {
+			*format = importFormat(strings.TrimPrefix(filepath.Ext(path), "."), "")
+		}
+	}
+
+	src, err := newRowReader(importFormat(*format, ""), in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report, err := importUsers(db, src, importFormat(*format, ""), *mode, *batchSize)
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "导入完成: 共 %d 行, 成功 %d 行, 失败 %d 行, 耗时 %s\n", report.Total, report.Inserted, report.Failed, report.Elapsed)
+	return 0
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
+  "context"
   "fmt"
-  "database/sql/driver"
-  "encoding/json"
   "gorm.io/gorm"
   "gorm.io/driver/postgres"
   "github.com/gin-gonic/gin"
@@ -12,47 +11,19 @@ import (
   "gorm.io/gorm/logger"
   "log"
   "os"
+  "strconv"
+
+  "gorm-shared/apierror"
+  "gorm-shared/batching"
+  "gorm-shared/datetypes"
+  "gorm-shared/replica"
 )
 
 type User struct {
   gorm.Model
   Name string `json:"name" binding:"required" gorm:"default:anonymous"`
   Age int `json:"age" binding:"required,gt=0" gorm:"default:18"`
-  Birthday Date `gorm:"type:date" json:"birthday" binding:"required"`
-}
-
-type Date time.Time
-
-func (d *Date) UnmarshalJSON(b []byte) error {
-  var dateStr string
-  if err := json.Unmarshal(b, &dateStr); err != nil {
-    return err
-  }
-
-  date, err := time.Parse("2006-01-02", dateStr)
-  if err != nil {
-    return err
-  }
-  *d = Date(date)
-  return nil
-}
-
-func (d Date) MarshalJSON() ([]byte, error) {
-
-  return json.Marshal(time.Time(d).Format("2006-01-02"))
-}
-
-func (d Date) Value() (driver.Value, error){
-  return time.Time(d), nil
-}
-
-func (d *Date) Scan(value interface{}) error {
-  v, ok := value.(time.Time)
-  if !ok {
-    return fmt.Errorf("invalid type %T for Date", value)
-  }
-  *d = Date(v)
-  return nil
+  Birthday datetypes.Date `gorm:"type:date" json:"birthday" binding:"required"`
 }
 
 func main(){
@@ -68,54 +39,96 @@ func main(){
 
   dns := "host=localhost user=postgres password=123456 dbname=dvdrental port=5432 sslmode=disable timezone=Asia/Shanghai"
   db, err := gorm.Open(postgres.Open(dns), &gorm.Config{
-    CreateBatchSize: 1000,
+    // 按最宽模型的列数和 65535 个绑定参数的上限计算，而不是固定行数
+    CreateBatchSize: batching.SizeFor(batching.MaxBindParams, &User{}, &UpsertedUser{}),
     Logger: newLogger, // 使用配置的日志记录器
   })
   if err != nil {
     panic("failed to connect database")
   }
 
-  db.AutoMigrate(&User{})
+  db.AutoMigrate(&User{}, &IdempotencyKey{})
+
+  // 读写分离: 配置了 DB_REPLICAS 时读请求走副本，写入和事务始终在主库
+  replicaCfg, err := replica.ConfigFromEnv()
+  if err != nil {
+    panic(err)
+  }
+  resolver, err := replica.Register(db, replicaCfg)
+  if err != nil {
+    panic(err)
+  }
+
+  // 命令行: go run . import -mode skip users.csv / go run . bench -n 100000
+  if len(os.Args) > 1 {
+    quiet := db.Session(&gorm.Session{Logger: newLogger.LogMode(logger.Warn)})
+    switch os.Args[1] {
+    case "import":
+      os.Exit(runImportCommand(quiet, os.Args[2:]))
+    case "bench":
+      os.Exit(runBenchCommand(quiet, os.Args[2:]))
+    }
+  }
+
+  // 幂等记录的保留时间和清理间隔
+  idempotencyTTL := envDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+  go cleanupIdempotencyKeys(context.Background(), db, envDuration("IDEMPOTENCY_CLEANUP_INTERVAL", 10*time.Minute))
+  idem := idempotency(db, idempotencyTTL)
 
   r := gin.Default()
-  r.POST("/users", func(c *gin.Context) {
+  // 统一错误响应格式
+  apierror.Install(r)
+  // 写入之后 DB_READ_YOUR_WRITES 时长内，同一个客户端的读请求走主库
+  r.Use(resolver.ReadYourWrites())
+
+  // 副本的健康状态和复制延迟
+  r.GET("/replicas", func(c *gin.Context){
+    c.JSON(http.StatusOK, gin.H{"policy": resolver.Policy(), "replicas": resolver.Statuses()})
+  })
+
+  // 带 Idempotency-Key 头的重试请求不会重复创建用户
+  r.POST("/users", idem, func(c *gin.Context) {
     var user User
-    if err := c.ShouldBindJSON(&user); err != nil {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if err := apierror.BindJSON(c, &user); err != nil {
+      apierror.Respond(c, err)
       return
     }
-    if err := db.Create(&user).Error; err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    if err := txFrom(c, db).Create(&user).Error; err != nil {
+      apierror.Respond(c, err)
       return
     }
     c.JSON(http.StatusCreated, user)
   })
   
   // 批量新增用户
-  r.POST("/users/batch", func(c *gin.Context){
+  r.POST("/users/batch", idem, func(c *gin.Context){
     var users []*User
-    if err := c.ShouldBindJSON(&users); err != nil {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if err := apierror.BindJSON(c, &users); err != nil {
+      apierror.Respond(c, err)
       return
     }
 
-    if err := db.Create(&users).Error; err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    report, err := batching.Create(txFrom(c, db), &users, batching.Options{})
+    if err != nil {
+      apierror.Respond(c, err)
       return
     }
+    for _, b := range report.Batches {
+      fmt.Printf("批量新增第 %d 批: %d 行, %d 个参数, 耗时 %s\n", b.Index+1, b.Rows, b.Params, b.Elapsed)
+    }
     c.JSON(http.StatusCreated, users)
   })
 
   // 指定字段新增
   r.POST("/users/partial", func(c *gin.Context){
     var user User
-    if err := c.ShouldBindJSON(&user); err != nil {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if err := apierror.BindJSON(c, &user); err != nil {
+      apierror.Respond(c, err)
       return
     }
 
     if err := db.Select("Name", "Birthday").Create(&user).Error; err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+      apierror.Respond(c, err)
       return
     }
     c.JSON(http.StatusCreated, user)
@@ -124,13 +137,13 @@ func main(){
   // 忽略指定字段新增
   r.POST("/users/ignore", func(c *gin.Context){
     var user User
-    if err := c.ShouldBindJSON(&user); err != nil {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if err := apierror.BindJSON(c, &user); err != nil {
+      apierror.Respond(c, err)
       return
     }
 
     if err := db.Omit("Age").Create(&user).Error; err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+      apierror.Respond(c, err)
       return
     }
     c.JSON(http.StatusCreated, user)
@@ -144,17 +157,117 @@ func main(){
       users = append(users, &User{
         Name: fmt.Sprintf("User %d", i),
         Age: i + 1,
-        Birthday: Date(time.Now()),
+        Birthday: datetypes.Today(),
       })
     }
 
     if err := db.CreateInBatches(users, 100).Error; err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+      apierror.Respond(c, err)
       return
     }
     c.JSON(http.StatusCreated, gin.H{"message": "200 users created"})
   })
 
+  // 用 COPY 批量新增，适合大批量导入，不受绑定参数个数限制
+  r.POST("/users/batch/copy", func(c *gin.Context){
+    var users []*User
+    if err := apierror.BindJSON(c, &users); err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+
+    report, err := copyFrom(db.WithContext(c.Request.Context()), &users)
+    if err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+    c.JSON(http.StatusCreated, gin.H{"report": report, "users": users})
+  })
+
+  // 新增或更新单个用户
+  // ?on=id (冲突列，默认主键) 或 ?constraint=users_pkey，?update=age,birthday (冲突时更新的列) 或 ?do_nothing=true
+  r.PUT("/users/upsert", func(c *gin.Context){
+    opts, err := parseUpsertOptions(c, db)
+    if err != nil {
+      apierror.Respond(c, apierror.BadRequest("%v", err))
+      return
+    }
+    var user UpsertedUser
+    if err := apierror.BindJSON(c, &user); err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+
+    report, err := upsertUsers(db, []UpsertedUser{user}, opts)
+    if err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+    user = report.Users[0]
+    status := http.StatusOK
+    if user.Action == UpsertInserted {
+      status = http.StatusCreated
+    }
+    c.JSON(status, user)
+  })
+
+  // 批量 upsert，参数同上，返回新增、更新、跳过的数量和每一行的结果
+  r.PUT("/users/upsert/batch", func(c *gin.Context){
+    opts, err := parseUpsertOptions(c, db)
+    if err != nil {
+      apierror.Respond(c, apierror.BadRequest("%v", err))
+      return
+    }
+    var users []UpsertedUser
+    if err := apierror.BindJSON(c, &users); err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+
+    report, err := upsertUsers(db, users, opts)
+    if err != nil {
+      apierror.Respond(c, err)
+      return
+    }
+    c.JSON(http.StatusOK, report)
+  })
+
+  // 流式导入，请求体是 CSV 或 NDJSON，不会一次性读入内存
+  // ?format=csv|ndjson (默认按 Content-Type 判断) &mode=abort|skip &batch_size= (默认按列数计算)
+  r.POST("/users/import", func(c *gin.Context){
+    format := importFormat(c.Query("format"), c.ContentType())
+    mode := c.DefaultQuery("mode", ImportAbort)
+    if mode != ImportAbort && mode != ImportSkip {
+      apierror.Respond(c, apierror.BadRequest("mode 只能是 abort 或 skip"))
+      return
+    }
+    batchSize, err := strconv.Atoi(c.DefaultQuery("batch_size", "0"))
+    if err != nil || batchSize < 0 {
+      apierror.Respond(c, apierror.BadRequest("batch_size 必须是非负整数"))
+      return
+    }
+
+    src, err := newRowReader(format, c.Request.Body)
+    if err != nil {
+      apierror.Respond(c, apierror.BadRequest("%v", err))
+      return
+    }
+
+    report, err := importUsers(db.WithContext(c.Request.Context()), src, format, mode, batchSize)
+    if err != nil {
+      // 只有 abort 模式会回滚；skip 模式下出错之前的批次已经提交，report 里的 ID 仍然有效
+      code, message := "import_aborted", "导入失败，已回滚: %v"
+      if !report.Aborted {
+        code, message = "import_failed", "导入中断，之前的批次已提交: %v"
+      }
+      // 出错行的字段错误放在 errors 里，完整的逐行报告放在 details 里
+      apierror.Respond(c, apierror.Newf(http.StatusUnprocessableEntity, code, message, err).
+        WithErrors(apierror.From(err).Errors...).
+        WithDetails(report))
+      return
+    }
+    c.JSON(http.StatusCreated, report)
+  })
 
   r.Run(":8080")
 }
\ No newline at end of file
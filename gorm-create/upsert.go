@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/batching"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// upsert 之后每一行的处理结果
+const (
+	UpsertInserted = "inserted"
+	UpsertUpdated  = "updated"
+	UpsertSkipped  = "skipped" // do_nothing 模式下遇到冲突，没有写入
+)
+
+// UpsertedUser upsert 的请求和返回，Inserted 只从 RETURNING (xmax = 0) 读取，不会写入也不参与迁移
+type UpsertedUser struct {
+	User
+	Inserted bool   `json:"inserted" gorm:"->;-:migration"`
+	Action   string `json:"action" gorm:"-"`
+}
+
+func (UpsertedUser) TableName() string {
+	return "users"
+}
+
+// UpsertOptions 冲突目标和冲突时的处理方式
+type UpsertOptions struct {
+	Columns    []string `json:"columns,omitempty"`    // 冲突列，需要有对应的唯一索引
+	Constraint string   `json:"constraint,omitempty"` // 约束名，与 Columns 二选一
+	Update     []string `json:"update,omitempty"`     // 冲突时更新的列
+	DoNothing  bool     `json:"do_nothing"`
+}
+
+// UpsertReport 批量 upsert 的统计
+type UpsertReport struct {
+	Options  UpsertOptions        `json:"options"`
+	Inserted int                  `json:"inserted"`
+	Updated  int                  `json:"updated"`
+	Skipped  int                  `json:"skipped"`
+	Batches  []batching.BatchStat `json:"batches,omitempty"`
+	Users    []UpsertedUser       `json:"users"`
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var userSchemaCache = &sync.Map{}
+
+// parseUpsertOptions 读取 ?on=id&constraint=&update=age,birthday&do_nothing=true
+// 列名用模型的 schema 校验，默认冲突目标是主键，默认更新除主键、created_at、deleted_at 以外的所有列
+func parseUpsertOptions(c *gin.Context, db *gorm.DB) (UpsertOptions, error) {
+	var opts UpsertOptions
+	sch, err := schema.Parse(&User{}, userSchemaCache, db.NamingStrategy)
+	if err != nil {
+		return opts, err
+	}
+
+	lookup := func(name string) (*schema.Field, error) {
+		field := sch.LookUpField(strings.TrimSpace(name))
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		return field, nil
+	}
+
+	if s := c.Query("do_nothing"); s != "" {
+		if opts.DoNothing, err = strconv.ParseBool(s); err != nil {
+			return opts, fmt.Errorf("invalid do_nothing %q", s)
+		}
+	}
+
+	opts.Constraint = c.Query("constraint")
+	on := c.Query("on")
+	switch {
+	case opts.Constraint != "" && on != "":
+		return opts, errors.New("on and constraint cannot be used together")
+	case opts.Constraint != "":
+		// 约束名会原样拼进 SQL，必须先确认它是 users 表上真实存在的约束
+		if !identifierPattern.MatchString(opts.Constraint) || !db.Migrator().HasConstraint(&User{}, opts.Constraint) {
+			return opts, fmt.Errorf("unknown constraint %q", opts.Constraint)
+		}
+	case on != "":
+		for _, name := range strings.Split(on, ",") {
+			field, err := lookup(name)
+			if err != nil {
+				return opts, err
+			}
+			opts.Columns = append(opts.Columns, field.DBName)
+		}
+	default:
+		opts.Columns = []string{sch.PrioritizedPrimaryField.DBName}
+	}
+
+	if opts.DoNothing {
+		if c.Query("update") != "" {
+			return opts, errors.New("update cannot be used with do_nothing")
+		}
+		return opts, nil
+	}
+
+	if s := c.Query("update"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			field, err := lookup(name)
+			if err != nil {
+				return opts, err
+			}
+			if field.PrimaryKey || !field.Updatable {
+				return opts, fmt.Errorf("column %q cannot be updated", name)
+			}
+			opts.Update = append(opts.Update, field.DBName)
+		}
+	} else {
+		for _, field := range sch.Fields {
+			if field.DBName == "" || field.PrimaryKey || !field.Updatable || field.AutoCreateTime > 0 || field.Name == "DeletedAt" {
+				continue
+			}
+			opts.Update = append(opts.Update, field.DBName)
+		}
+	}
+	// 更新时总是刷新 updated_at
+	hasUpdatedAt := false
+	for _, col := range opts.Update {
+		hasUpdatedAt = hasUpdatedAt || col == "updated_at"
+	}
+	if !hasUpdatedAt {
+		opts.Update = append(opts.Update, "updated_at")
+	}
+	return opts, nil
+}
+
+func (o UpsertOptions) onConflict() clause.OnConflict {
+	onConflict := clause.OnConflict{OnConstraint: o.Constraint, DoNothing: o.DoNothing}
+	for _, col := range o.Columns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: col})
+	}
+	if !o.DoNothing {
+		onConflict.DoUpdates = clause.AssignmentColumns(o.Update)
+	}
+	return onConflict
+}
+
+// upsertReturning 返回整行以及是否为新插入的行
+// 新插入的行 xmax 为 0；ON CONFLICT DO UPDATE 更新的行 xmax 是当前事务 ID
+var upsertReturning = clause.Returning{Columns: []clause.Column{
+	{Name: "*", Raw: true},
+	{Name: "(xmax = 0)", Alias: "inserted", Raw: true},
+}}
+
+// upsertUsers 执行 INSERT ... ON CONFLICT ... RETURNING *, (xmax = 0) AS inserted
+func upsertUsers(db *gorm.DB, users []UpsertedUser, opts UpsertOptions) (UpsertReport, error) {
+	report := UpsertReport{Options: opts, Users: users}
+	if len(users) == 0 {
+		return report, nil
+	}
+
+	if opts.DoNothing {
+		// DO NOTHING 跳过的行没有 RETURNING 结果，gorm 按顺序回填时会错位，所以逐行插入
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for i := range users {
+				result := tx.Clauses(opts.onConflict(), upsertReturning).Create(&users[i])
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					users[i].Action = UpsertSkipped
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	} else {
+		// 批大小按列数计算，每批一条 INSERT ... ON CONFLICT
+		batches, err := batching.Create(db, &users, batching.Options{
+			Clauses: []clause.Expression{opts.onConflict(), upsertReturning},
+		})
+		report.Batches = batches.Batches
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for i := range users {
+		switch {
+		case users[i].Action == UpsertSkipped:
+			report.Skipped++
+		case users[i].Inserted:
+			users[i].Action = UpsertInserted
+			report.Inserted++
+		default:
+			users[i].Action = UpsertUpdated
+			report.Updated++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 归档删除: 彻底删除记录，把 DELETE ... RETURNING to_jsonb(t.*) 返回的整行存进 archived_records 的 jsonb 列，
+// 可以连同信用卡和语言关联一起归档。和回收站不同，归档的记录不在原表里，不受唯一索引和保留期影响，
+// 需要时用 jsonb_populate_record 插回原表 (主键不变)。
+
+// HeaderUser 操作人，记录在归档里；没有时记为客户端 IP
+const HeaderUser = "X-User"
+
+// ErrAlreadyRehydrated 归档的记录已经还原过
+var ErrAlreadyRehydrated = errors.New("archived record already rehydrated")
+
+// archivedAssociations 归档时可以一起删除的关联表，都通过 user_id 引用 users
+var archivedAssociations = []string{"credit_cards", "user_languages"}
+
+// AssociationsError 不归档关联时用户还有关联行 (包括回收站里的)，外键不允许删除用户
+type AssociationsError struct {
+	Rows map[string]int64 `json:"rows"` // 按表名统计的关联行数
+}
+
+func (e *AssociationsError) Error() string {
+	tables := make([]string, 0, len(e.Rows))
+	for _, table := range archivedAssociations {
+		if n, ok := e.Rows[table]; ok {
+			tables = append(tables, fmt.Sprintf("%s: %d", table, n))
+		}
+	}
+	return "user still has associations (" + strings.Join(tables, ", ") + ")"
+}
+
+// JSONData 存在 jsonb 列里的数据
+type JSONData []byte
+
+func (d JSONData) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+func (d *JSONData) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*d = append((*d)[:0], v...)
+	case string:
+		*d = JSONData(v)
+	case nil:
+		*d = nil
+	default:
+		return fmt.Errorf("invalid type %T for JSONData", value)
+	}
+	return nil
+}
+
+func (d JSONData) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+func (d *JSONData) UnmarshalJSON(b []byte) error {
+	*d = append((*d)[:0], b...)
+	return nil
+}
+
+// ArchivedRecord archived_records 表，可以保存任何表的记录
+type ArchivedRecord struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	SourceTable string `json:"source_table" gorm:"not null;index:idx_archived_records_source,priority:1"`
+	RecordID    string `json:"record_id" gorm:"not null;index:idx_archived_records_source,priority:2"`
+	// Data 被删除的行，键是列名，和 to_jsonb(row) 相同
+	Data JSONData `json:"data" gorm:"type:jsonb;not null"`
+	// Associations 一起删除的关联行，按表名分组: {"credit_cards": [...], "user_languages": [...]}
+	Associations JSONData   `json:"associations,omitempty" gorm:"type:jsonb"`
+	DeletedBy    string     `json:"deleted_by" gorm:"not null"`
+	DeletedAt    time.Time  `json:"deleted_at" gorm:"not null;index"`
+	Reason       string     `json:"reason" gorm:"not null"`
+	RehydratedAt *time.Time `json:"rehydrated_at"`
+	RehydratedBy string     `json:"rehydrated_by,omitempty"`
+}
+
+// ArchiveRequest 归档删除的参数
+type ArchiveRequest struct {
+	Reason       string `json:"reason" binding:"required,max=500"`
+	Associations bool   `json:"associations"` // 同时删除并归档信用卡和语言关联；为 false 时有关联的用户返回 409
+}
+
+// archiveUser 彻底删除用户 (包括回收站里的)，把删除的行写入 archived_records
+func archiveUser(db *gorm.DB, id uint, by string, req ArchiveRequest) (ArchivedRecord, error) {
+	var record ArchivedRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 先查出来再删除，BeforeDelete 钩子才能看到 Role
+		var user User
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		// 删除用原生 SQL 才能 RETURNING 整行 (gorm 只能扫描到 User 结构体里，其他 demo 给 users 加的 version、
+		// search_vector 等列会丢失)，所以像 gorm 一样在删除关联之前自己调用钩子
+		if err := user.BeforeDelete(tx); err != nil {
+			return err
+		}
+
+		// 不归档关联时先检查，否则 DELETE 会因为外键失败 (回收站里的关联行也还在表里)
+		if !req.Associations {
+			rows := map[string]int64{}
+			for _, table := range archivedAssociations {
+				var n int64
+				if err := tx.Table(table).Where("user_id = ?", id).Count(&n).Error; err != nil {
+					return err
+				}
+				if n > 0 {
+					rows[table] = n
+				}
+			}
+			if len(rows) > 0 {
+				return &AssociationsError{Rows: rows}
+			}
+		}
+
+		associations := map[string][]JSONData{}
+		if req.Associations {
+			for _, table := range archivedAssociations {
+				rows, err := deleteReturning(tx, table, "user_id = ?", id)
+				if err != nil {
+					return err
+				}
+				if len(rows) > 0 {
+					associations[table] = rows
+				}
+			}
+		}
+
+		rows, err := deleteReturning(tx, "users", "id = ?", user.ID)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		record = ArchivedRecord{
+			SourceTable: "users",
+			RecordID:    strconv.FormatUint(uint64(user.ID), 10),
+			DeletedBy:   by,
+			DeletedAt:   tx.NowFunc(),
+			Reason:      req.Reason,
+		}
+		record.Data = rows[0]
+		if len(associations) > 0 {
+			if record.Associations, err = json.Marshal(associations); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&record).Error
+	})
+	return record, err
+}
+
+// deleteReturning 删除 table 中满足条件的行，返回每一行的 to_jsonb，包括模型里没有的列
+func deleteReturning(tx *gorm.DB, table, where string, args ...interface{}) ([]JSONData, error) {
+	var rows []JSONData
+	quoted := tx.Statement.Quote(table)
+	err := tx.Raw(fmt.Sprintf("DELETE FROM %s WHERE %s RETURNING to_jsonb(%s.*)", quoted, where, quoted), args...).Scan(&rows).Error
+	return rows, err
+}
+
+// insertRow 把 to_jsonb 得到的一行插回 table
+// 只插入 JSON 里有的列，缺少的列 (归档之后新加的列) 使用默认值；生成列不能插入，由数据库重新计算
+func insertRow(tx *gorm.DB, table string, row json.RawMessage) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(row, &values); err != nil {
+		return err
+	}
+	var columns []string
+	err := tx.Raw(`SELECT attname FROM pg_attribute
+		WHERE attrelid = ?::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = ''
+		ORDER BY attnum`, table).Scan(&columns).Error
+	if err != nil {
+		return err
+	}
+	var quoted []string
+	for _, column := range columns {
+		if _, ok := values[column]; ok {
+			quoted = append(quoted, tx.Statement.Quote(column))
+		}
+	}
+	if len(quoted) == 0 {
+		return fmt.Errorf("归档的数据里没有 %s 的列", table)
+	}
+	cols := strings.Join(quoted, ", ")
+	return tx.Exec(fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM jsonb_populate_record(NULL::%[1]s, ?::jsonb)",
+		tx.Statement.Quote(table), cols), string(row)).Error
+}
+
+// listArchive 按删除时间倒序列出归档的记录，table、recordID 为空时不过滤
+func listArchive(db *gorm.DB, table, recordID string, limit int) ([]ArchivedRecord, error) {
+	tx := db.Order("deleted_at DESC, id DESC").Limit(limit)
+	if table != "" {
+		tx = tx.Where("source_table = ?", table)
+	}
+	if recordID != "" {
+		tx = tx.Where("record_id = ?", recordID)
+	}
+	records := []ArchivedRecord{}
+	err := tx.Find(&records).Error
+	return records, err
+}
+
+// rehydrate 把归档的记录插回原表，关联行插回各自的表，主键和时间戳保持不变
+// 原表里已经有同样主键的行、或者名字和未删除的用户重复时返回唯一约束错误，归档不变
+func rehydrate(db *gorm.DB, id uint, by string) (ArchivedRecord, error) {
+	var record ArchivedRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, id).Error; err != nil {
+			return err
+		}
+		if record.RehydratedAt != nil {
+			return ErrAlreadyRehydrated
+		}
+
+		// 先插回主表，关联行的外键才有引用
+		if err := insertRow(tx, record.SourceTable, json.RawMessage(record.Data)); err != nil {
+			return err
+		}
+
+		if len(record.Associations) > 0 {
+			var associations map[string][]json.RawMessage
+			if err := json.Unmarshal(record.Associations, &associations); err != nil {
+				return err
+			}
+			for _, table := range archivedAssociations {
+				for _, row := range associations[table] {
+					if err := insertRow(tx, table, row); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		now := tx.NowFunc()
+		record.RehydratedAt = &now
+		record.RehydratedBy = by
+		return tx.Model(&record).Select("RehydratedAt", "RehydratedBy").Updates(&record).Error
+	})
+	return record, err
+}
+
+// registerArchiveRoutes 归档接口
+//
+//	POST /users/:id/archive           {"reason": "...", "associations": true}
+//	GET  /archive?table=users&record_id=1&limit=100
+//	GET  /archive/:id
+//	POST /archive/:id/rehydrate
+func registerArchiveRoutes(r *gin.Engine, db *gorm.DB) {
+	r.POST("/users/:id/archive", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		var req ArchiveRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		record, err := archiveUser(db.WithContext(c.Request.Context()), id, operator(c), req)
+		if err != nil {
+			var assocErr *AssociationsError
+			switch {
+			case errors.As(err, &assocErr):
+				apierror.Respond(c, apierror.Newf(http.StatusConflict, "has_associations",
+					"用户 %d 还有信用卡或语言关联，需要设置 associations: true 一起归档", id).WithDetails(assocErr))
+			case errors.Is(err, ErrAdminDelete):
+				apierror.Respond(c, apierror.Newf(http.StatusForbidden, "forbidden", "用户 %d 是管理员，不能删除", id))
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				apierror.Respond(c, apierror.NotFound("用户 %d 不存在", id))
+			default:
+				apierror.Respond(c, err)
+			}
+			return
+		}
+		c.JSON(http.StatusCreated, record)
+	})
+
+	r.GET("/archive", func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > 1000 {
+			apierror.Respond(c, apierror.BadRequest("limit 必须是 1 到 1000 之间的整数"))
+			return
+		}
+		records, err := listArchive(db.WithContext(c.Request.Context()), c.Query("table"), c.Query("record_id"), limit)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, records)
+	})
+
+	r.GET("/archive/:id", func(c *gin.Context) {
+		id, ok := archiveID(c)
+		if !ok {
+			return
+		}
+		var record ArchivedRecord
+		if err := db.WithContext(c.Request.Context()).First(&record, id).Error; err != nil {
+			respondArchiveError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, record)
+	})
+
+	// 插回原表；主键或名字和现有的用户冲突时返回 409
+	r.POST("/archive/:id/rehydrate", func(c *gin.Context) {
+		id, ok := archiveID(c)
+		if !ok {
+			return
+		}
+		record, err := rehydrate(db.WithContext(c.Request.Context()), id, operator(c))
+		if err != nil {
+			respondArchiveError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, record)
+	})
+}
+
+// operator X-User 请求头，没有时用客户端 IP
+func operator(c *gin.Context) string {
+	if user := c.GetHeader(HeaderUser); user != "" {
+		return user
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func archiveID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		apierror.Respond(c, apierror.BadRequest("无效的归档 ID: %q", c.Param("id")))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondArchiveError(c *gin.Context, id uint, err error) {
+	switch {
+	case errors.Is(err, ErrAlreadyRehydrated):
+		apierror.Respond(c, apierror.Newf(http.StatusConflict, "already_rehydrated", "归档 %d 已经还原过", id))
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		apierror.Respond(c, apierror.NotFound("归档 %d 不存在", id))
+	default:
+		apierror.Respond(c, err)
+	}
+}
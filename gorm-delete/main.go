@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -16,17 +21,44 @@ import (
 
 type User struct {
 	gorm.Model
-	Name     string    `json:"name" gorm:"default:anonymous"`
+	Name     string    `json:"name" gorm:"default:anonymous;liveUnique"` // 在未删除的用户中唯一，见 softunique
 	Age      int       `json:"age" gorm:"default:18"`
 	Birthday time.Time `json:"birthday"`
 	LockTest string    `json:"lock_test"`
 	Role     string    `json:"role" gorm:"default:user"`
+
+	CreditCards []CreditCard `json:"credit_cards,omitempty" gorm:"foreignKey:UserID"`
+	Languages   []Language   `json:"languages,omitempty" gorm:"many2many:UserLanguage;"`
+}
+
+type CreditCard struct {
+	gorm.Model
+	Number     string    `json:"number"`
+	UserID     uint      `json:"user_id"`
+	ExpireDate time.Time `json:"expire_date"`
+}
+
+type Language struct {
+	gorm.Model
+	Name  string `json:"name"`
+	Users []User `json:"users,omitempty" gorm:"many2many:UserLanguage;"`
 }
 
+// UserLanguage 自定义连接表，带 DeletedAt 才能随用户一起软删除和恢复
+type UserLanguage struct {
+	UserID     uint `gorm:"primaryKey"`
+	LanguageID uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+}
+
+// ErrAdminDelete 管理员不能被删除
+var ErrAdminDelete = errors.New("admin cannot be deleted")
+
 func (u *User) BeforeDelete(tx *gorm.DB) (err error) {
 	fmt.Println("==============================触发了BeforeDelete==============================")
 	if u.Role == "admin" {
-		return errors.New("admin cannot be deleted")
+		return ErrAdminDelete
 	}
 	return nil
 }
@@ -49,6 +81,25 @@ func main() {
 		panic("failed to connect database")
 	}
 
+	db.SetupJoinTable(&User{}, "Languages", &UserLanguage{})
+	db.AutoMigrate(&User{}, &CreditCard{}, &Language{}, &ArchivedRecord{})
+	// 名字只在未删除的用户中唯一，删除之后可以再创建同名用户；唯一索引由 go run . migrate-unique 创建，
+	// 迁移会锁住 users 表，不在每次启动时执行 (其他 demo 也在用这张表)
+
+	// 命令行: go run . serve 启动回收站接口 / go run . trash list|restore|purge|purge-expired
+	// go run . migrate-unique -dedupe 处理重复的名字并创建唯一索引
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(db)
+			return
+		case "trash":
+			os.Exit(runTrashCommand(db.Session(&gorm.Session{Logger: newLogger.LogMode(logger.Warn)}), os.Args[2:]))
+		case "migrate-unique":
+			os.Exit(runMigrateUniqueCommand(db, os.Args[2:]))
+		}
+	}
+
 	// 删除一条记录
 	user := User{Model: gorm.Model{ID: 623}}
 	db.Delete(&user)
@@ -89,6 +140,7 @@ func main() {
 		fmt.Println("json.Marshal error:", err2)
 	}
 	fmt.Println("user json:", string(byteArr))
+	// 返回的数据可以保存下来，见 archive.go: archiveUser 把 RETURNING 的整行写入 archived_records，之后可以还原
 
 	// 如果你并不想嵌套gorm.Model，你也可以像下方例子那样开启软删除特性：
 	type Actor struct {
@@ -120,4 +172,136 @@ func main() {
 	//	  DeletedAt soft_delete.DeletedAt `gorm:"uniqueIndex:udx_name"`
 	//	}
 
+	// 这里用的是另一种做法: 保留 gorm.DeletedAt，创建只约束 deleted_at IS NULL 的部分唯一索引，见 User.Name 的 liveUnique 标签
+
+}
+
+// serve 回收站接口，同时在后台定期彻底删除超过保留期的记录
+func serve(db *gorm.DB) {
+	retention := envDuration("TRASH_RETENTION", defaultTrashRetention)
+	go runPurger(context.Background(), db, retention, envDuration("TRASH_PURGE_INTERVAL", defaultPurgeInterval))
+
+	r := gin.Default()
+	apierror.Install(r)
+
+	// 创建用户，名字和已删除的用户相同也可以创建，和未删除的用户相同时返回 409
+	r.POST("/users", func(c *gin.Context) {
+		var user User
+		if err := c.ShouldBindJSON(&user); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if err := db.WithContext(c.Request.Context()).Omit("CreditCards", "Languages").Create(&user).Error; err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, user)
+	})
+
+	// 删除用户，信用卡和语言关联一起软删除，可以从回收站恢复
+	r.DELETE("/users/:id", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		report, err := trashUser(db.WithContext(c.Request.Context()), id)
+		if err != nil {
+			respondTrashError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	// 归档删除: 彻底删除并把删除的行保存到 archived_records，可以浏览和还原
+	registerArchiveRoutes(r, db)
+
+	// 删除计划: 统计 db.Select(...).Delete(&user) 会删除哪些行、触发哪些外键动作，再按计划执行
+	registerPlanRoutes(r, db)
+
+	// 回收站里的用户: ?limit=100
+	r.GET("/trash/users", func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > 1000 {
+			apierror.Respond(c, apierror.BadRequest("limit 必须是 1 到 1000 之间的整数"))
+			return
+		}
+		entries, err := listTrash(db.WithContext(c.Request.Context()), retention, limit)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// 恢复用户以及和它一起删除的信用卡、语言关联
+	r.POST("/trash/users/:id/restore", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		report, err := restoreUser(db.WithContext(c.Request.Context()), id)
+		if err != nil {
+			respondTrashError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	// 彻底删除回收站里的用户，信用卡和语言关联一起删除
+	r.DELETE("/trash/users/:id", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		report, err := purgeUser(db.WithContext(c.Request.Context()), id)
+		if err != nil {
+			respondTrashError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	// 立即清理超过保留期的记录: ?older_than=720h，默认使用 TRASH_RETENTION
+	r.POST("/trash/purge", func(c *gin.Context) {
+		olderThan := retention
+		if s := c.Query("older_than"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				apierror.Respond(c, apierror.BadRequest("无效的 older_than: %q", s))
+				return
+			}
+			olderThan = d
+		}
+		report, err := purgeExpired(c.Request.Context(), db, olderThan, defaultPurgeBatchSize)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	r.Run(":8080")
+}
+
+// userID 解析路径里的用户 ID，无效时写入 400 响应
+func userID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		apierror.Respond(c, apierror.BadRequest("无效的用户 ID: %q", c.Param("id")))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondTrashError(c *gin.Context, id uint, err error) {
+	switch {
+	case errors.Is(err, ErrAdminDelete):
+		apierror.Respond(c, apierror.Newf(http.StatusForbidden, "forbidden", "用户 %d 是管理员，不能删除", id))
+	case errors.Is(err, gorm.ErrRecordNotFound) && c.Request.Method == http.MethodDelete && c.FullPath() == "/users/:id":
+		apierror.Respond(c, apierror.NotFound("用户 %d 不存在或已在回收站里", id))
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		apierror.Respond(c, apierror.NotFound("用户 %d 不在回收站里", id))
+	default:
+		apierror.Respond(c, err)
+	}
 }
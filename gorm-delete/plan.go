@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+	"gorm-shared/deleteplan"
+	"gorm.io/gorm"
+)
+
+// registerPlanRoutes 删除计划接口
+//
+//	GET  /users/:id/delete-plan?select=CreditCards,Languages&unscoped=true   只统计，不删除
+//	POST /users/:id/delete-plan/execute?select=...&unscoped=...               请求体可以带上 GET 返回的计划，行数变了就返回 409
+func registerPlanRoutes(r *gin.Engine, db *gorm.DB) {
+	r.GET("/users/:id/delete-plan", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		opts, ok := planOptions(c)
+		if !ok {
+			return
+		}
+		tx := db.WithContext(c.Request.Context())
+		var user User
+		if err := tx.Unscoped().First(&user, id).Error; err != nil {
+			respondPlanError(c, id, err)
+			return
+		}
+		plan, err := deleteplan.Build(tx, &user, opts)
+		if err != nil {
+			respondPlanError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+	})
+
+	r.POST("/users/:id/delete-plan/execute", func(c *gin.Context) {
+		id, ok := userID(c)
+		if !ok {
+			return
+		}
+		opts, ok := planOptions(c)
+		if !ok {
+			return
+		}
+		var expected *deleteplan.Plan
+		if c.Request.ContentLength != 0 {
+			expected = &deleteplan.Plan{}
+			if err := c.ShouldBindJSON(expected); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+		}
+		tx := db.WithContext(c.Request.Context())
+		var user User
+		if err := tx.Unscoped().First(&user, id).Error; err != nil {
+			respondPlanError(c, id, err)
+			return
+		}
+		plan, err := deleteplan.Execute(tx, &user, opts, expected)
+		if err != nil {
+			respondPlanError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+	})
+}
+
+// planOptions 解析 select 和 unscoped 参数，select=* 表示全部关联
+func planOptions(c *gin.Context) (deleteplan.Options, bool) {
+	var opts deleteplan.Options
+	for _, name := range strings.Split(c.Query("select"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Select = append(opts.Select, name)
+		}
+	}
+	if s := c.Query("unscoped"); s != "" {
+		unscoped, err := strconv.ParseBool(s)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("无效的 unscoped: %q", s))
+			return opts, false
+		}
+		opts.Unscoped = unscoped
+	}
+	return opts, true
+}
+
+func respondPlanError(c *gin.Context, id uint, err error) {
+	var mismatch *deleteplan.MismatchError
+	var willFail *deleteplan.WillFailError
+	switch {
+	case errors.As(err, &mismatch):
+		apierror.Respond(c, apierror.New(http.StatusConflict, "plan_mismatch", "数据已经变化，和计划不一致，没有删除").WithDetails(mismatch))
+	case errors.As(err, &willFail):
+		apierror.Respond(c, apierror.New(http.StatusConflict, "plan_will_fail", willFail.Error()).WithDetails(willFail.Plan))
+	case errors.Is(err, ErrAdminDelete):
+		apierror.Respond(c, apierror.Newf(http.StatusForbidden, "forbidden", "用户 %d 是管理员，不能删除", id))
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		apierror.Respond(c, apierror.NotFound("用户 %d 不存在", id))
+	default:
+		apierror.Respond(c, err)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 回收站
+//
+// 删除用户时，用户和它的信用卡、语言关联 (user_languages) 在同一个事务里软删除，deleted_at 使用同一个时间戳。
+// 恢复时只恢复 deleted_at 与用户相同的子记录，在这之前单独删除的信用卡不会被一起恢复。
+// 彻底删除 (purge) 时先删子记录再删用户，单独删除的子记录也一起删掉，否则外键会阻止删除用户；
+// 回收站里的管理员 (BeforeDelete 拒绝删除) 连同子记录一起保留，在结果的 skipped 里列出。
+//
+// 超过保留期 (TRASH_RETENTION，默认 30 天) 的记录由后台任务每隔 TRASH_PURGE_INTERVAL (默认 1 小时) 彻底删除，
+// 也可以调用 POST /trash/purge 或执行 go run . trash purge-expired 手动触发。
+
+// 默认保留期和清理参数
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeBatchSize = 500
+)
+
+// TrashEntry 回收站里的一个用户
+type TrashEntry struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Role        string    `json:"role"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	PurgeAt     time.Time `json:"purge_at"`     // 超过这个时间会被后台任务彻底删除
+	CreditCards int64     `json:"credit_cards"` // 恢复时会一起恢复的信用卡数
+	Languages   int64     `json:"languages"`    // 恢复时会一起恢复的语言关联数
+}
+
+// CascadeReport 一次删除、恢复或彻底删除影响的行数
+type CascadeReport struct {
+	Users       int64      `json:"users"`
+	CreditCards int64      `json:"credit_cards"`
+	Languages   int64      `json:"languages"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Skipped     []uint     `json:"skipped,omitempty"` // BeforeDelete 钩子不允许删除 (管理员) 而留在回收站里的用户
+}
+
+func (r *CascadeReport) add(other CascadeReport) {
+	r.Users += other.Users
+	r.CreditCards += other.CreditCards
+	r.Languages += other.Languages
+	r.Skipped = append(r.Skipped, other.Skipped...)
+}
+
+// trashUser 软删除用户以及它的信用卡和语言关联，所有记录的 deleted_at 相同
+func trashUser(db *gorm.DB, id uint) (CascadeReport, error) {
+	var report CascadeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		// 先查出来再删除，BeforeDelete 钩子才能看到 Role
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+
+		// 软删除使用 NowFunc 生成 deleted_at，固定成同一个值；Postgres 只保存到微秒
+		deletedAt := time.Now().Truncate(time.Microsecond)
+		tx = tx.Session(&gorm.Session{NowFunc: func() time.Time { return deletedAt }})
+
+		result := tx.Where("user_id = ?", id).Delete(&CreditCard{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.CreditCards = result.RowsAffected
+
+		result = tx.Where("user_id = ?", id).Delete(&UserLanguage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Languages = result.RowsAffected
+
+		result = tx.Delete(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Users = result.RowsAffected
+		report.DeletedAt = &deletedAt
+		return nil
+	})
+	return report, err
+}
+
+// listTrash 按删除时间倒序列出回收站里的用户
+func listTrash(db *gorm.DB, retention time.Duration, limit int) ([]TrashEntry, error) {
+	entries := []TrashEntry{}
+	err := db.Unscoped().Model(&User{}).
+		Select(`users.id, users.name, users.role, users.deleted_at,
+			(SELECT count(*) FROM credit_cards c WHERE c.user_id = users.id AND c.deleted_at = users.deleted_at) AS credit_cards,
+			(SELECT count(*) FROM user_languages ul WHERE ul.user_id = users.id AND ul.deleted_at = users.deleted_at) AS languages`).
+		Where("users.deleted_at IS NOT NULL").
+		Order("users.deleted_at DESC, users.id").
+		Limit(limit).
+		Scan(&entries).Error
+	for i := range entries {
+		entries[i].PurgeAt = entries[i].DeletedAt.Add(retention)
+	}
+	return entries, err
+}
+
+// trashedUser 锁住回收站里的用户，不在回收站里时返回 gorm.ErrRecordNotFound
+func trashedUser(tx *gorm.DB, id uint) (User, error) {
+	var user User
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("deleted_at IS NOT NULL").
+		First(&user, id).Error
+	return user, err
+}
+
+// restoreUser 恢复用户以及和它一起删除的信用卡、语言关联
+func restoreUser(db *gorm.DB, id uint) (CascadeReport, error) {
+	var report CascadeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		user, err := trashedUser(tx, id)
+		if err != nil {
+			return err
+		}
+		deletedAt := user.DeletedAt.Time
+		report.DeletedAt = &deletedAt
+
+		result := tx.Unscoped().Model(&CreditCard{}).
+			Where("user_id = ? AND deleted_at = ?", id, deletedAt).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.CreditCards = result.RowsAffected
+
+		result = tx.Unscoped().Model(&UserLanguage{}).
+			Where("user_id = ? AND deleted_at = ?", id, deletedAt).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Languages = result.RowsAffected
+
+		result = tx.Unscoped().Model(&user).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Users = result.RowsAffected
+		return nil
+	})
+	return report, err
+}
+
+// purgeUsers 彻底删除回收站里的这些用户和它们所有的信用卡、语言关联，需要在事务里调用
+// 先查出用户逐个调用 BeforeDelete，钩子拒绝的管理员连同子记录一起跳过，记在 Skipped 里
+func purgeUsers(tx *gorm.DB, ids []uint) (CascadeReport, error) {
+	var report CascadeReport
+	if len(ids) == 0 {
+		return report, nil
+	}
+	tx = tx.Unscoped().Session(&gorm.Session{})
+
+	var users []User
+	if err := tx.Where("id IN ? AND deleted_at IS NOT NULL", ids).Find(&users).Error; err != nil {
+		return report, err
+	}
+	ids = ids[:0:0]
+	for i := range users {
+		switch err := users[i].BeforeDelete(tx); {
+		case errors.Is(err, ErrAdminDelete):
+			report.Skipped = append(report.Skipped, users[i].ID)
+		case err != nil:
+			return report, err
+		default:
+			ids = append(ids, users[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return report, nil
+	}
+
+	result := tx.Where("user_id IN ?", ids).Delete(&CreditCard{})
+	if result.Error != nil {
+		return report, result.Error
+	}
+	report.CreditCards = result.RowsAffected
+
+	result = tx.Where("user_id IN ?", ids).Delete(&UserLanguage{})
+	if result.Error != nil {
+		return report, result.Error
+	}
+	report.Languages = result.RowsAffected
+
+	// Delete(&User{}) 的 BeforeDelete 只能看到空模型，所以上面先对查出来的用户逐个调用
+	result = tx.Where("id IN ?", ids).Delete(&User{})
+	if result.Error != nil {
+		return report, result.Error
+	}
+	report.Users = result.RowsAffected
+	return report, nil
+}
+
+// purgeUser 彻底删除回收站里的一个用户，管理员返回 ErrAdminDelete
+func purgeUser(db *gorm.DB, id uint) (CascadeReport, error) {
+	var report CascadeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := trashedUser(tx, id); err != nil {
+			return err
+		}
+		var err error
+		if report, err = purgeUsers(tx, []uint{id}); err == nil && len(report.Skipped) > 0 {
+			err = ErrAdminDelete
+		}
+		return err
+	})
+	return report, err
+}
+
+// purgeExpired 彻底删除 deleted_at 早于 now - retention 的用户，以及单独删除且同样过期的信用卡和语言关联
+// 每批最多 batchSize 个用户，一批一个事务，避免长时间持有大量行锁；SKIP LOCKED 跳过正在被恢复的用户
+func purgeExpired(ctx context.Context, db *gorm.DB, retention time.Duration, batchSize int) (CascadeReport, error) {
+	var total CascadeReport
+	cutoff := time.Now().Add(-retention)
+	db = db.WithContext(ctx)
+
+	for {
+		var ids []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			query := tx.Unscoped().Model(&User{}).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("deleted_at < ?", cutoff)
+			// 跳过的管理员还在表里，不排除的话每一批都会再查到它们
+			if len(total.Skipped) > 0 {
+				query = query.Where("id NOT IN ?", total.Skipped)
+			}
+			err := query.Order("deleted_at").Limit(batchSize).Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			report, err := purgeUsers(tx, ids)
+			total.add(report)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(ids) < batchSize {
+			break
+		}
+	}
+
+	// 和用户一起删除的子记录留给用户那一批处理，上面因为 SKIP LOCKED 跳过的用户可能正在被恢复
+	result := db.Unscoped().
+		Where("deleted_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM users u WHERE u.id = credit_cards.user_id AND u.deleted_at = credit_cards.deleted_at)").
+		Delete(&CreditCard{})
+	if result.Error != nil {
+		return total, result.Error
+	}
+	total.CreditCards += result.RowsAffected
+
+	result = db.Unscoped().
+		Where("deleted_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM users u WHERE u.id = user_languages.user_id AND u.deleted_at = user_languages.deleted_at)").
+		Delete(&UserLanguage{})
+	if result.Error != nil {
+		return total, result.Error
+	}
+	total.Languages += result.RowsAffected
+	return total, nil
+}
+
+// runPurger 每隔 interval 彻底删除一次超过保留期的记录，ctx 取消后退出
+func runPurger(ctx context.Context, db *gorm.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := purgeExpired(ctx, db, retention, defaultPurgeBatchSize)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Printf("清理回收站失败: %v\n", err)
+			} else if report.Users+report.CreditCards+report.Languages > 0 {
+				fmt.Printf("已彻底删除 %d 个用户、%d 张信用卡、%d 条语言关联\n", report.Users, report.CreditCards, report.Languages)
+			}
+			if len(report.Skipped) > 0 {
+				fmt.Printf("回收站里的管理员不能彻底删除，已跳过: %v\n", report.Skipped)
+			}
+		}
+	}
+}
+
+// envDuration 读取时长类型的环境变量，未设置或格式错误时使用默认值
+func envDuration(name string, def time.Duration) time.Duration {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		fmt.Printf("环境变量 %s=%q 无效，使用默认值 %s\n", name, s, def)
+		return def
+	}
+	return d
+}
+
+// runTrashCommand 命令行: trash list | restore <id> | purge <id> | purge-expired [-retention 720h] [-batch 500]
+func runTrashCommand(db *gorm.DB, args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: trash list [-limit 100] | restore <id> | purge <id> | purge-expired [-retention 720h] [-batch 500]")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	retention := envDuration("TRASH_RETENTION", defaultTrashRetention)
+
+	var result interface{}
+	var err error
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		limit := fs.Int("limit", 100, "max users to list")
+		fs.Parse(args[1:])
+		result, err = listTrash(db, retention, *limit)
+	case "restore", "purge":
+		if len(args) != 2 {
+			return usage()
+		}
+		id, parseErr := strconv.ParseUint(args[1], 10, 64)
+		if parseErr != nil {
+			return usage()
+		}
+		if args[0] == "restore" {
+			result, err = restoreUser(db, uint(id))
+		} else {
+			result, err = purgeUser(db, uint(id))
+		}
+	case "purge-expired":
+		fs := flag.NewFlagSet("purge-expired", flag.ExitOnError)
+		fs.DurationVar(&retention, "retention", retention, "purge rows deleted longer ago than this")
+		batchSize := fs.Int("batch", defaultPurgeBatchSize, "users per transaction")
+		fs.Parse(args[1:])
+		if retention <= 0 || *batchSize <= 0 {
+			return usage()
+		}
+		result, err = purgeExpired(context.Background(), db, retention, *batchSize)
+	default:
+		return usage()
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Fprintf(os.Stderr, "用户 %s 不在回收站里\n", args[1])
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(result)
+	return 0
+}
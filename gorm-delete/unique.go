@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gorm-shared/softunique"
+	"gorm.io/gorm"
+)
+
+// runMigrateUniqueCommand 命令行: migrate-unique [-dedupe]
+// 不加 -dedupe 时只检查，有重复的名字就报错退出；加上后每组保留 ID 最小的用户，其余和 DELETE /users/:id 一样
+// 连同信用卡、语言关联一起移进回收站，有管理员时迁移失败。
+// 这些用户只能彻底删除：和保留的用户同名，恢复会违反唯一索引，除非先把保留的那个改名或删除
+func runMigrateUniqueCommand(db *gorm.DB, args []string) int {
+	fs := flag.NewFlagSet("migrate-unique", flag.ExitOnError)
+	dedupe := fs.Bool("dedupe", false, "soft-delete duplicates among live rows, keeping the lowest id")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: migrate-unique [-dedupe]")
+		return 2
+	}
+
+	reports, err := softunique.Migrate(db, softunique.Options{Dedupe: *dedupe, Trash: trashDuplicates}, &User{})
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(reports)
+	if softunique.IsDuplicate(err) {
+		fmt.Fprintf(os.Stderr, "%v\n加上 -dedupe 软删除重复的用户\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// trashDuplicates 用 trashUser 软删除去重时多余的用户，BeforeDelete 和子记录的级联都和普通删除一样
+func trashDuplicates(tx *gorm.DB, ids []string) (int64, error) {
+	var total int64
+	for _, s := range ids {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return total, err
+		}
+		report, err := trashUser(tx, uint(id))
+		if err != nil {
+			return total, fmt.Errorf("软删除重复的用户 %d: %w", id, err)
+		}
+		total += report.Users
+	}
+	return total, nil
+}
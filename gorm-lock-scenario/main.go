@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm-shared/versioning"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// 支持的操作，与 gorm-advanced-select 的接口一一对应
+const (
+	OpLock        = "lock"         // PUT /users/lock/:latency/:id
+	OpQuickUpdate = "quick_update" // PUT /users/quick-update/:id
+	OpRead        = "read"         // GET /users/read/:id
+)
+
+// Actor 场景里的一个参与者
+type Actor struct {
+	Name    string            `yaml:"name" json:"name"`
+	Start   time.Duration     `yaml:"start" json:"start"`     // 相对场景开始的偏移，如 500ms
+	Op      string            `yaml:"op" json:"op"`           // lock / quick_update / read
+	ID      uint              `yaml:"id" json:"id"`           // 操作的用户 ID
+	Seconds int               `yaml:"seconds" json:"seconds"` // lock 操作持锁的秒数
+	Params  map[string]string `yaml:"params" json:"params"`   // 透传给接口的查询参数，如 mode=nowait，仅 mode=http 时生效
+}
+
+// Scenario 一个完整的并发场景
+type Scenario struct {
+	Name   string  `yaml:"name" json:"name"`
+	Actors []Actor `yaml:"actors" json:"actors"`
+}
+
+// Result 一次操作的时间线
+type Result struct {
+	Actor    Actor
+	Start    time.Time
+	Acquired time.Time // 拿到锁（或读到数据）的时间，按本地时钟
+	Finish   time.Time
+	Status   int
+	Err      string
+}
+
+// Wait 等锁耗时
+func (r Result) Wait() time.Duration {
+	if r.Acquired.IsZero() {
+		return r.Finish.Sub(r.Start)
+	}
+	return r.Acquired.Sub(r.Start)
+}
+
+// Total 整个操作耗时
+func (r Result) Total() time.Duration {
+	return r.Finish.Sub(r.Start)
+}
+
+// Executor 执行一个操作，填充 Acquired / Status / Err
+type Executor interface {
+	Execute(ctx context.Context, a Actor, res *Result)
+}
+
+func loadScenario(path string) (Scenario, error) {
+	var s Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	// YAML 是 JSON 的超集，两种格式都用 yaml 解析
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	for i, a := range s.Actors {
+		if a.Name == "" {
+			s.Actors[i].Name = fmt.Sprintf("%s#%d", a.Op, i+1)
+		}
+		switch a.Op {
+		case OpLock, OpQuickUpdate, OpRead:
+		default:
+			return s, fmt.Errorf("actor %d: unknown op %q", i+1, a.Op)
+		}
+		if a.ID == 0 {
+			return s, fmt.Errorf("actor %d: id is required", i+1)
+		}
+	}
+	return s, nil
+}
+
+// run 按各自的偏移并发执行所有操作
+func run(ctx context.Context, s Scenario, exec Executor) []Result {
+	results := make([]Result, len(s.Actors))
+	begin := time.Now()
+
+	var wg sync.WaitGroup
+	for i, a := range s.Actors {
+		wg.Add(1)
+		go func(i int, a Actor) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				results[i] = Result{Actor: a, Err: ctx.Err().Error()}
+				return
+			case <-time.After(time.Until(begin.Add(a.Start))):
+			}
+
+			res := Result{Actor: a, Start: time.Now()}
+			exec.Execute(ctx, a, &res)
+			res.Finish = time.Now()
+			results[i] = res
+		}(i, a)
+	}
+	wg.Wait()
+	return results
+}
+
+// httpExecutor 通过 gorm-advanced-select 的 HTTP 接口执行操作
+type httpExecutor struct {
+	base   string
+	client *http.Client
+}
+
+func (e httpExecutor) Execute(ctx context.Context, a Actor, res *Result) {
+	method, path := http.MethodPut, ""
+	switch a.Op {
+	case OpLock:
+		path = fmt.Sprintf("/users/lock/%d/%d", a.Seconds, a.ID)
+	case OpQuickUpdate:
+		path = fmt.Sprintf("/users/quick-update/%d", a.ID)
+	case OpRead:
+		method, path = http.MethodGet, fmt.Sprintf("/users/read/%d", a.ID)
+	}
+
+	query := url.Values{}
+	for k, v := range a.Params {
+		query.Set(k, v)
+	}
+	target := strings.TrimRight(e.base, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		res.Err = err.Error()
+		return
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		res.Err = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+
+	body, _ := io.ReadAll(resp.Body)
+	var payload struct {
+		StartedAt  time.Time `json:"started_at"`
+		AcquiredAt time.Time `json:"acquired_at"`
+		Error      string    `json:"error"`
+	}
+	json.Unmarshal(body, &payload)
+	// 服务端的两个时间戳相减得到等锁耗时，再换算到本地时间线上，
+	// 不直接拿服务端的 acquired_at 和本地的 Start 相减，两台机器的时钟偏差会混进等待时间
+	if !payload.StartedAt.IsZero() && !payload.AcquiredAt.IsZero() {
+		res.Acquired = res.Start.Add(payload.AcquiredAt.Sub(payload.StartedAt))
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		res.Err = payload.Error
+		if res.Err == "" {
+			res.Err = resp.Status
+		}
+	}
+}
+
+// User 与 gorm-advanced-select 中的模型保持一致
+type User struct {
+	gorm.Model
+	Name     string `json:"name" gorm:"default:anonymous"`
+	Age      int    `json:"age" gorm:"default:18"`
+	LockTest string `json:"lock_test"`
+	Version  int64  `json:"version" gorm:"not null;default:1"`
+}
+
+// gormExecutor 直接通过 GORM 执行同样的操作，时间戳不受 HTTP 往返影响
+type gormExecutor struct {
+	db *gorm.DB
+}
+
+func (e gormExecutor) Execute(ctx context.Context, a Actor, res *Result) {
+	db := e.db.WithContext(ctx)
+	res.Status = http.StatusOK
+
+	if a.Op == OpRead {
+		var user User
+		if err := db.Take(&user, a.ID).Error; err != nil {
+			res.Status, res.Err = http.StatusInternalServerError, err.Error()
+			return
+		}
+		res.Acquired = time.Now()
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).Take(&user, a.ID).Error; err != nil {
+			return err
+		}
+		res.Acquired = time.Now()
+
+		if a.Op == OpLock {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(a.Seconds) * time.Second):
+			}
+		}
+		return tx.Model(&user).Update("lock_test", fmt.Sprintf("%s by %s at %s", a.Op, a.Name, time.Now().Format(time.RFC3339))).Error
+	})
+	if err != nil {
+		res.Status, res.Err = http.StatusInternalServerError, err.Error()
+	}
+}
+
+// percentile 已排序切片的第 p 百分位
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[idx]
+}
+
+// printPercentiles 按操作类型打印等锁和总耗时的分位数
+func printPercentiles(w io.Writer, results []Result) {
+	byOp := map[string][]Result{}
+	for _, r := range results {
+		if r.Start.IsZero() {
+			continue
+		}
+		byOp[r.Actor.Op] = append(byOp[r.Actor.Op], r)
+	}
+	ops := make([]string, 0, len(byOp))
+	for op := range byOp {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(w, "\n%-20s %-6s %-7s %12s %12s %12s %12s\n", "op", "count", "errors", "p50", "p90", "p99", "max")
+	for _, op := range ops {
+		var waits, totals []time.Duration
+		errCount := 0
+		for _, r := range byOp[op] {
+			if r.Err != "" {
+				errCount++
+			}
+			waits = append(waits, r.Wait())
+			totals = append(totals, r.Total())
+		}
+		for _, row := range []struct {
+			label string
+			ds    []time.Duration
+		}{{op + " wait", waits}, {op + " total", totals}} {
+			sort.Slice(row.ds, func(i, j int) bool { return row.ds[i] < row.ds[j] })
+			fmt.Fprintf(w, "%-20s %-6d %-7d %12s %12s %12s %12s\n", row.label, len(row.ds), errCount,
+				percentile(row.ds, 50).Round(time.Millisecond),
+				percentile(row.ds, 90).Round(time.Millisecond),
+				percentile(row.ds, 99).Round(time.Millisecond),
+				row.ds[len(row.ds)-1].Round(time.Millisecond))
+		}
+	}
+}
+
+func main() {
+	var (
+		file     = flag.String("f", "scenarios/contention.yaml", "场景文件 (JSON 或 YAML)")
+		mode     = flag.String("mode", "http", "执行方式: http 或 gorm")
+		base     = flag.String("base", "http://localhost:8080", "gorm-advanced-select 服务地址 (mode=http)")
+		dsn      = flag.String("dsn", "host=localhost user=postgres password=123456 dbname=dvdrental port=5432 sslmode=disable timezone=Asia/Shanghai", "数据库连接 (mode=gorm)")
+		htmlOut  = flag.String("html", "", "输出 HTML 时间线到指定文件")
+		width    = flag.Int("width", 80, "ASCII 时间线宽度")
+		deadline = flag.Duration("timeout", 5*time.Minute, "整个场景的最长运行时间")
+	)
+	flag.Parse()
+
+	scenario, err := loadScenario(*file)
+	if err != nil {
+		log.Fatalf("加载场景失败: %v", err)
+	}
+
+	var exec Executor
+	switch *mode {
+	case "http":
+		exec = httpExecutor{base: *base, client: &http.Client{}}
+	case "gorm":
+		db, err := gorm.Open(postgres.Open(*dsn), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Warn),
+		})
+		if err != nil {
+			panic("failed to connect database")
+		}
+		// 和 gorm-advanced-select 一样由回调把 version 加一
+		if err := versioning.Register(db); err != nil {
+			panic(err)
+		}
+		exec = gormExecutor{db: db}
+	default:
+		log.Fatalf("未知的执行方式 %q", *mode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *deadline)
+	defer cancel()
+
+	fmt.Printf("运行场景 %q, 共 %d 个操作, 方式: %s\n", scenario.Name, len(scenario.Actors), *mode)
+	results := run(ctx, scenario, exec)
+
+	renderASCII(os.Stdout, results, *width)
+	printPercentiles(os.Stdout, results)
+
+	if *htmlOut != "" {
+		f, err := os.Create(*htmlOut)
+		if err != nil {
+			log.Fatalf("创建 HTML 文件失败: %v", err)
+		}
+		defer f.Close()
+		if err := renderHTML(f, scenario.Name, results); err != nil {
+			log.Fatalf("生成 HTML 失败: %v", err)
+		}
+		fmt.Println("HTML 时间线已写入", *htmlOut)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// bounds 所有已执行操作的最早开始和最晚结束时间
+func bounds(results []Result) (time.Time, time.Time) {
+	var first, last time.Time
+	for _, r := range results {
+		if r.Start.IsZero() {
+			continue
+		}
+		if first.IsZero() || r.Start.Before(first) {
+			first = r.Start
+		}
+		if r.Finish.After(last) {
+			last = r.Finish
+		}
+	}
+	return first, last
+}
+
+// renderASCII 每个操作一行: '.' 表示等锁, '=' 表示持锁/执行, 'x' 表示以错误结束
+func renderASCII(w io.Writer, results []Result, width int) {
+	first, last := bounds(results)
+	span := last.Sub(first)
+	if span <= 0 {
+		span = time.Millisecond
+	}
+	col := func(t time.Time) int {
+		c := int(float64(t.Sub(first)) / float64(span) * float64(width-1))
+		return min(max(c, 0), width-1)
+	}
+
+	nameWidth := 4
+	for _, r := range results {
+		nameWidth = max(nameWidth, len(r.Actor.Name))
+	}
+
+	fmt.Fprintf(w, "\n%-*s |%s| %s\n", nameWidth, "name", strings.Repeat("-", width), span.Round(time.Millisecond))
+	for _, r := range results {
+		line := []byte(strings.Repeat(" ", width))
+		if !r.Start.IsZero() {
+			start, end := col(r.Start), col(r.Finish)
+			acquired := end
+			if !r.Acquired.IsZero() {
+				acquired = col(r.Acquired)
+			}
+			for i := start; i <= end; i++ {
+				if i < acquired {
+					line[i] = '.'
+				} else {
+					line[i] = '='
+				}
+			}
+			if r.Err != "" {
+				line[end] = 'x'
+			}
+		}
+
+		status := fmt.Sprintf("%d wait=%s total=%s", r.Status, r.Wait().Round(time.Millisecond), r.Total().Round(time.Millisecond))
+		if r.Err != "" {
+			status += " err=" + r.Err
+		}
+		fmt.Fprintf(w, "%-*s |%s| %s\n", nameWidth, r.Actor.Name, line, status)
+	}
+}
+
+var htmlTimeline = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: monospace; }
+.row { position: relative; height: 22px; margin: 4px 0; background: #f4f4f4; }
+.label { display: inline-block; width: 200px; }
+.track { position: absolute; left: 200px; right: 0; top: 0; bottom: 0; }
+.wait { position: absolute; height: 100%; background: #f0c36d; }
+.hold { position: absolute; height: 100%; background: #5b9bd5; }
+.error .hold { background: #d9534f; }
+</style>
+</head>
+<body>
+<h2>{{.Name}} ({{.Span}})</h2>
+{{range .Rows}}
+<div class="row{{if .Err}} error{{end}}" title="{{.Title}}">
+  <span class="label">{{.Label}}</span>
+  <div class="track">
+    <div class="wait" style="left: {{.Start}}%; width: {{.Wait}}%"></div>
+    <div class="hold" style="left: {{.Acquired}}%; width: {{.Hold}}%"></div>
+  </div>
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// renderHTML 输出一个可以直接在浏览器打开的时间线页面
+func renderHTML(w io.Writer, name string, results []Result) error {
+	first, last := bounds(results)
+	span := last.Sub(first)
+	if span <= 0 {
+		span = time.Millisecond
+	}
+	pct := func(t time.Time) float64 {
+		return float64(t.Sub(first)) / float64(span) * 100
+	}
+
+	type row struct {
+		Label, Title, Err           string
+		Start, Wait, Acquired, Hold float64
+	}
+	var rows []row
+	for _, r := range results {
+		if r.Start.IsZero() {
+			continue
+		}
+		acquired := r.Acquired
+		if acquired.IsZero() {
+			acquired = r.Finish
+		}
+		rows = append(rows, row{
+			Label:    fmt.Sprintf("%s %s #%d", r.Actor.Name, r.Actor.Op, r.Actor.ID),
+			Title:    fmt.Sprintf("status=%d wait=%s total=%s %s", r.Status, r.Wait().Round(time.Millisecond), r.Total().Round(time.Millisecond), r.Err),
+			Err:      r.Err,
+			Start:    pct(r.Start),
+			Wait:     pct(acquired) - pct(r.Start),
+			Acquired: pct(acquired),
+			Hold:     pct(r.Finish) - pct(acquired),
+		})
+	}
+
+	return htmlTimeline.Execute(w, map[string]interface{}{
+		"Name": name,
+		"Span": span.Round(time.Millisecond).String(),
+		"Rows": rows,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/listquery"
+)
+
+// userETag 强 ETag，由 ID、updated_at (精确到数据库的微秒) 和 ?fields 决定
+// 同一条记录不同字段集的响应内容不同，ETag 也必须不同
+func userETag(id uint, updatedAt time.Time, fields *listquery.Fieldset) string {
+	tag := fmt.Sprintf("%d-%x", id, updatedAt.UnixMicro())
+	if key := fields.Key(); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		tag += "-" + hex.EncodeToString(sum[:4])
+	}
+	return `"` + tag + `"`
+}
+
+// etagMatches If-None-Match 用弱比较：忽略 W/ 前缀，* 匹配任何已存在的记录
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConditional 请求带了 If-None-Match 或 If-Modified-Since
+func hasConditional(c *gin.Context) bool {
+	return c.GetHeader("If-None-Match") != "" || c.GetHeader("If-Modified-Since") != ""
+}
+
+// setValidators 写入 ETag、Last-Modified，并要求客户端每次都来校验
+func setValidators(c *gin.Context, etag string, lastModified time.Time) {
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-cache")
+}
+
+// notModified 按 RFC 9110 判断客户端缓存是否仍然有效：有 If-None-Match 时忽略 If-Modified-Since
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if header := c.GetHeader("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+	if header := c.GetHeader("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+		// HTTP 日期只精确到秒
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
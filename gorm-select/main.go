@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"gorm.io/driver/postgres"
@@ -9,54 +10,23 @@ import (
 	"time"
 	"gorm.io/gorm/logger"
 	"net/http"
-	"fmt"
-	"database/sql/driver"
-	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gorm-shared/apierror"
+	"gorm-shared/batching"
+	"gorm-shared/datetypes"
+	"gorm-shared/listquery"
+	"gorm-shared/replica"
 )
 
 type User struct {
   gorm.Model
   Name string `json:"name" binding:"required" gorm:"default:anonymous"`
   Age int `json:"age" binding:"required,gt=0" gorm:"default:18"`
-  Birthday Date `gorm:"type:date" json:"birthday" binding:"required"`
+  Birthday datetypes.Date `gorm:"type:date" json:"birthday" binding:"required"`
 }
 
-type Date time.Time
-
-func (d *Date) UnmarshalJSON(b []byte) error {
-  var dateStr string
-  if err := json.Unmarshal(b, &dateStr); err != nil {
-    return err
-  }
-
-  date, err := time.Parse("2006-01-02", dateStr)
-  if err != nil {
-    return err
-  }
-  *d = Date(date)
-  return nil
-}
-
-func (d Date) MarshalJSON() ([]byte, error) {
-
-  return json.Marshal(time.Time(d).Format("2006-01-02"))
-}
-
-func (d Date) Value() (driver.Value, error){
-  return time.Time(d), nil
-}
-
-func (d *Date) Scan(value interface{}) error {
-  v, ok := value.(time.Time)
-  if !ok {
-    return fmt.Errorf("invalid type %T for Date", value)
-  }
-  *d = Date(v)
-  return nil
-}
-
-
-
 func main(){
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
@@ -70,32 +40,122 @@ func main(){
 
 	db, err := gorm.Open(postgres.Open("host=localhost user=postgres password=123456 dbname=dvdrental port=5432 sslmode=disable timezone=Asia/Shanghai"), &gorm.Config{
 		Logger: newLogger,
-		CreateBatchSize: 1000,
+		// 按列数和 65535 个绑定参数的上限计算批大小，而不是固定行数
+		CreateBatchSize: batching.SizeFor(batching.MaxBindParams, &User{}),
 	})
 	if err != nil {
 		panic("failed to connect database")
 	}
 
 	db.AutoMigrate(&User{})
+	// 搜索用的生成列和索引由 go run . migrate-search 创建，需要 pg_trgm 扩展；
+	// 添加生成列会重写整张 users 表，不在每次启动时执行 (其他 demo 也在用这张表)
+	if len(os.Args) > 1 && os.Args[1] == "migrate-search" {
+		if err := migrateSearch(db); err != nil {
+			log.Fatalf("创建搜索索引失败: %v", err)
+		}
+		log.Println("搜索索引已就绪")
+		return
+	}
+
+	// 读写分离: 配置了 DB_REPLICAS 时读请求走副本，迁移在注册之前完成，始终在主库上执行
+	replicaCfg, err := replica.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	resolver, err := replica.Register(db, replicaCfg)
+	if err != nil {
+		panic(err)
+	}
 
 	r := gin.Default()
+	apierror.Install(r)
+	// 写入之后 DB_READ_YOUR_WRITES 时长内，同一个客户端的读请求走主库
+	r.Use(resolver.ReadYourWrites())
+
+	// 副本的健康状态和复制延迟
+	r.GET("/replicas", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{"policy": resolver.Policy(), "replicas": resolver.Statuses()})
+	})
 
-	// 查询所有用户
+	// 查询用户列表，支持过滤、排序和分页:
+	// ?age[gte]=18&name[like]=马%&sort=-age,name&page=2&per_page=50
+	// 总数在 X-Total-Count 头里，翻页链接在 Link 头里
+	// 数据量大时用 ?cursor= 按游标翻页，不统计总数，翻页链接同样在 Link 头里
+	// ?fields=name,age 只查询和返回这些字段
 	r.GET("/users", func(c *gin.Context){
-		var users []User
-		db.Find(&users)
-		c.JSON(http.StatusOK, users)
+		users := []User{}
+		q, err := listquery.Find(c, db, &users, listquery.Options{})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, q.Project(c.Request.Context(), users))
 	})
 
-	// 根据名字查询一个用户
+	// 按名字搜索: ?q=飞飞&limit=20，需要先执行 go run . migrate-search
+	// 中文按单字和相邻两字检索，拼错的字靠三元组相似度兜底，结果按相关度排序并标出命中部分
+	// 不放在 /users/ 下面，否则会和 /users/:name 抢名为 "search" 的用户
+	r.GET("/search/users", func(c *gin.Context){
+		q := strings.TrimSpace(c.Query("q"))
+		if q == "" {
+			apierror.Respond(c, apierror.BadRequest("q 不能为空"))
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			apierror.Respond(c, apierror.BadRequest("limit 必须在 1 到 100 之间"))
+			return
+		}
+		results, err := searchUsers(db.WithContext(c.Request.Context()), q, limit)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	})
+
+	// 根据名字查询一个用户，?fields=name,age 只返回这些字段
+	// 响应带 ETag 和 Last-Modified，客户端轮询时带上 If-None-Match 或 If-Modified-Since，没有变化返回 304
 	r.GET("/users/:name", func(c *gin.Context){
 		name := c.Param("name")
+		fields, err := listquery.ParseFields(db, &User{}, c.Query("fields"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		notFound := func(err error) {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = apierror.NotFound("用户 %q 不存在", name)
+			}
+			apierror.Respond(c, err)
+		}
+
+		tx := db.WithContext(c.Request.Context())
+		query := tx.Where("name = ?", name)
+		if hasConditional(c) {
+			// 条件请求先只查 id 和 updated_at，缓存仍然有效时不读整行
+			var meta User
+			if err := tx.Select("id", "updated_at").Where("name = ?", name).First(&meta).Error; err != nil {
+				notFound(err)
+				return
+			}
+			etag := userETag(meta.ID, meta.UpdatedAt, fields)
+			if notModified(c, etag, meta.UpdatedAt) {
+				setValidators(c, etag, meta.UpdatedAt)
+				c.Status(http.StatusNotModified)
+				return
+			}
+			query = tx.Where("id = ?", meta.ID)
+		}
+
 		var user User
-		if err := db.Where("name = ?", name).First(&user).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		if err := fields.Select(query, "id", "updated_at").First(&user).Error; err != nil {
+			notFound(err)
 			return
 		}
-		c.JSON(http.StatusOK, user)
+		setValidators(c, userETag(user.ID, user.UpdatedAt, fields), user.UpdatedAt)
+		c.JSON(http.StatusOK, fields.Project(c.Request.Context(), &user))
 	})
 	r.Run(":8080")
 }
\ No newline at end of file
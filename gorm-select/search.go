@@ -0,0 +1,257 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// 名字搜索
+//
+// Postgres 默认的文本解析器不会切分中文，"马飞飞" 会被当成一个词，搜 "飞飞" 搜不到。
+// 这里用一个 IMMUTABLE 函数 cjk_tokens 把连续的中日韩字符拆成单字和相邻两字 (bigram)，
+// 其他文字原样保留，再用 simple 配置生成 tsvector 存在生成列 search_vector 里。
+// 查询时在 Go 里用同样的规则切分，CJK 部分要求所有 bigram 都出现，拉丁文部分按前缀匹配。
+//
+// 全文检索要求字完全一致，拼错的字和 emoji 靠 pg_trgm 的相似度 (name % ?) 以及 ILIKE 子串匹配兜底。
+
+const (
+	searchVectorColumn = "search_vector"
+	searchVectorIndex  = "idx_users_search_vector"
+	nameTrgmIndex      = "idx_users_name_trgm"
+)
+
+// cjkTokensFunction 与 Go 里的 isCJK 覆盖相同的字符范围
+const cjkTokensFunction = `
+CREATE OR REPLACE FUNCTION cjk_tokens(input text) RETURNS text AS $$
+DECLARE
+	result text := '';
+	ch text;
+	prev text := '';
+BEGIN
+	FOR i IN 1..char_length(input) LOOP
+		ch := substr(input, i, 1);
+		IF ch ~ '[\u3040-\u30ff\u3400-\u4dbf\u4e00-\u9fff\uf900-\ufaff\uac00-\ud7af]' THEN
+			result := result || ' ' || ch;
+			IF prev <> '' THEN
+				result := result || ' ' || prev || ch;
+			END IF;
+			prev := ch;
+		ELSE
+			IF prev <> '' THEN
+				result := result || ' ';
+			END IF;
+			result := result || ch;
+			prev := '';
+		END IF;
+	END LOOP;
+	RETURN result;
+END
+$$ LANGUAGE plpgsql IMMUTABLE STRICT PARALLEL SAFE`
+
+// migrateSearch 创建 pg_trgm 扩展、cjk_tokens 函数、search_vector 生成列和两个 GIN 索引，由 migrate-search 命令调用
+// 已经存在的列和索引会跳过，可以重复执行
+func migrateSearch(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return err
+	}
+	if err := db.Exec(cjkTokensFunction).Error; err != nil {
+		return err
+	}
+
+	m := db.Migrator()
+	if !m.HasColumn(&User{}, searchVectorColumn) {
+		err := db.Exec("ALTER TABLE users ADD COLUMN " + searchVectorColumn +
+			" tsvector GENERATED ALWAYS AS (to_tsvector('simple', cjk_tokens(coalesce(name, '')))) STORED").Error
+		if err != nil {
+			return err
+		}
+	}
+	if !m.HasIndex(&User{}, searchVectorIndex) {
+		if err := db.Exec("CREATE INDEX " + searchVectorIndex + " ON users USING gin (" + searchVectorColumn + ")").Error; err != nil {
+			return err
+		}
+	}
+	if !m.HasIndex(&User{}, nameTrgmIndex) {
+		if err := db.Exec("CREATE INDEX " + nameTrgmIndex + " ON users USING gin (name gin_trgm_ops)").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchResult 一条搜索结果
+type SearchResult struct {
+	User
+	Score      float64 `json:"score" gorm:"->;-:migration"`      // rank + similarity，结果按它降序
+	Rank       float64 `json:"rank" gorm:"->;-:migration"`       // 全文检索的 ts_rank_cd
+	Similarity float64 `json:"similarity" gorm:"->;-:migration"` // pg_trgm 的相似度，容错拼写
+	Highlight  string  `json:"highlight" gorm:"-"`               // HTML 转义后的名字，命中部分用 <mark> 包起来
+}
+
+// isCJK 假名、CJK 统一汉字 (含扩展 A)、兼容汉字和韩文音节
+func isCJK(r rune) bool {
+	switch {
+	case r >= 0x3040 && r <= 0x30ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xac00 && r <= 0xd7af:
+		return true
+	}
+	return false
+}
+
+// searchTerms 把查询切成 CJK 片段和其他单词，都转成小写
+func searchTerms(q string) (cjk, words []string) {
+	var cur []rune
+	curCJK := false
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		if curCJK {
+			cjk = append(cjk, string(cur))
+		} else {
+			words = append(words, string(cur))
+		}
+		cur = cur[:0]
+	}
+	for _, r := range strings.ToLower(q) {
+		switch {
+		case isCJK(r):
+			if !curCJK {
+				flush()
+			}
+			curCJK = true
+			cur = append(cur, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if curCJK {
+				flush()
+			}
+			curCJK = false
+			cur = append(cur, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return cjk, words
+}
+
+// tsqueryLexeme 引用成 tsquery 的词素，'、\ 需要转义
+func tsqueryLexeme(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(s) + "'"
+}
+
+// buildTSQuery 生成 to_tsquery('simple', ?) 的参数，所有条件用 & 连接；没有可检索的词时返回空串
+// CJK 片段拆成相邻两字 (单字的片段就用单字)，与 cjk_tokens 生成的词一致；其他单词按前缀匹配
+func buildTSQuery(q string) string {
+	cjk, words := searchTerms(q)
+	var parts []string
+	for _, run := range cjk {
+		runes := []rune(run)
+		if len(runes) == 1 {
+			parts = append(parts, tsqueryLexeme(run))
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			parts = append(parts, tsqueryLexeme(string(runes[i:i+2])))
+		}
+	}
+	for _, w := range words {
+		parts = append(parts, tsqueryLexeme(w)+":*")
+	}
+	return strings.Join(parts, " & ")
+}
+
+// escapeLike 转义 LIKE 的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchUsers 全文检索、三元组相似度和子串匹配任一命中即返回，按 rank + similarity 排序
+func searchUsers(db *gorm.DB, q string, limit int) ([]SearchResult, error) {
+	tsq := buildTSQuery(q)
+	results := []SearchResult{}
+	err := db.Raw(`
+		SELECT users.*,
+			coalesce(ts_rank_cd(users.search_vector, query), 0) AS rank,
+			similarity(users.name, @q) AS similarity,
+			coalesce(ts_rank_cd(users.search_vector, query), 0) + similarity(users.name, @q) AS score
+		FROM users
+		LEFT JOIN LATERAL (SELECT CASE WHEN @tsq = '' THEN NULL ELSE to_tsquery('simple', @tsq) END AS query) q ON true
+		WHERE users.deleted_at IS NULL
+			AND (users.search_vector @@ query OR users.name % @q OR users.name ILIKE '%' || @like || '%')
+		ORDER BY score DESC, users.id
+		LIMIT @limit`,
+		map[string]interface{}{"q": q, "tsq": tsq, "like": escapeLike(q), "limit": limit},
+	).Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Highlight = highlight(results[i].Name, q)
+	}
+	return results, nil
+}
+
+// highlight 在 name 里标出查询命中的部分：先找整段的单词和 CJK 片段 (不区分大小写)，
+// 整段找不到的 CJK 片段再逐字标出，这样拼错一个字时其余的字仍然会被标出
+func highlight(name, q string) string {
+	runes := []rune(name)
+	lower := []rune(strings.ToLower(name))
+	if len(lower) != len(runes) {
+		// 个别字符转小写后长度变化，退回按原文匹配
+		lower = runes
+	}
+	marked := make([]bool, len(runes))
+	mark := func(term []rune) bool {
+		found := false
+		for i := 0; i+len(term) <= len(lower); i++ {
+			if string(lower[i:i+len(term)]) == string(term) {
+				for j := i; j < i+len(term); j++ {
+					marked[j] = true
+				}
+				found = true
+			}
+		}
+		return found
+	}
+
+	cjk, words := searchTerms(q)
+	for _, w := range words {
+		mark([]rune(w))
+	}
+	for _, run := range cjk {
+		term := []rune(run)
+		if mark(term) || len(term) == 1 {
+			continue
+		}
+		for _, r := range term {
+			mark([]rune{r})
+		}
+	}
+	// 查询里只有 emoji、符号时按整个查询匹配
+	if len(cjk) == 0 && len(words) == 0 {
+		mark([]rune(strings.ToLower(strings.TrimSpace(q))))
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		text := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			b.WriteString("<mark>" + text + "</mark>")
+		} else {
+			b.WriteString(text)
+		}
+		i = j
+	}
+	return b.String()
+}
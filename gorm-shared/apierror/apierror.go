@@ -0,0 +1,319 @@
+// Package apierror 统一 Gin 接口的错误响应格式。
+//
+// 所有错误都返回同一种 JSON 结构：
+//
+//	{
+//	  "code": "validation_failed",
+//	  "message": "请求参数校验失败",
+//	  "errors": [{"field": "age", "rule": "gt", "param": "0", "message": "age 必须大于 0", "code": "invalid_field"}],
+//	  "details": {...}
+//	}
+//
+// 处理函数只需要 apierror.Respond(c, err)，由 From 根据错误类型决定状态码和内容。
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm-shared/datetypes"
+	"gorm.io/gorm"
+)
+
+// 顶层错误码
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidation       = "validation_failed"
+	CodeInvalidJSON      = "invalid_json"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeConstraint       = "constraint_violation"
+	CodeLocked           = "locked"
+	CodeTimeout          = "timeout"
+	CodeCanceled         = "canceled"
+	CodeInternal         = "internal_error"
+)
+
+// StatusClientClosedRequest 客户端主动断开 (nginx 约定)
+const StatusClientClosedRequest = 499
+
+// FieldError 单个字段的错误
+type FieldError struct {
+	Field   string `json:"field"`           // JSON 字段名或数据库列名，无法确定时为空
+	Rule    string `json:"rule"`            // 违反的规则，如 required、gt、date、unique
+	Param   string `json:"param,omitempty"` // 规则参数，如 gt=0 中的 0
+	Message string `json:"message"`
+	Code    string `json:"code"` // missing_field、invalid_field、invalid_date、unique_violation 等
+}
+
+// Response 错误响应体
+type Response struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
+}
+
+// Error 带状态码的错误，可以直接作为 error 返回
+type Error struct {
+	Status int
+	Response
+	cause error
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// New 构造一个错误
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Response: Response{Code: code, Message: message}}
+}
+
+// Newf 构造一个错误，message 支持格式化
+func Newf(status int, code, format string, args ...interface{}) *Error {
+	return New(status, code, fmt.Sprintf(format, args...))
+}
+
+// BadRequest 请求参数错误 (查询参数、路径参数等)
+func BadRequest(format string, args ...interface{}) *Error {
+	return Newf(http.StatusBadRequest, CodeBadRequest, format, args...)
+}
+
+// NotFound 资源不存在
+func NotFound(format string, args ...interface{}) *Error {
+	return Newf(http.StatusNotFound, CodeNotFound, format, args...)
+}
+
+// WithDetails 附加额外信息，如阻塞者、导入报告
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+// WithErrors 附加字段错误
+func (e *Error) WithErrors(errs ...FieldError) *Error {
+	e.Errors = append(e.Errors, errs...)
+	return e
+}
+
+// WithCause 记录原始错误，Message 不变
+func (e *Error) WithCause(err error) *Error {
+	e.cause = err
+	return e
+}
+
+// Respond 按 From 的规则写出错误响应并终止后续处理
+func Respond(c *gin.Context, err error) {
+	e := From(err)
+	c.AbortWithStatusJSON(e.Status, e.Response)
+}
+
+// From 把任意错误转换成 *Error
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var verrs validator.ValidationErrors
+	var slice binding.SliceValidationError
+	var dateErr *datetypes.ParseError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var pgErr *pgconn.PgError
+
+	switch {
+	case errors.As(err, &slice):
+		e = New(http.StatusBadRequest, CodeValidation, "请求参数校验失败")
+		for _, item := range slice {
+			if errors.As(item, &verrs) {
+				e.WithErrors(validationErrors(verrs)...)
+			}
+		}
+		return e
+	case errors.As(err, &verrs):
+		return New(http.StatusBadRequest, CodeValidation, "请求参数校验失败").WithErrors(validationErrors(verrs)...)
+	case errors.As(err, &dateErr):
+		return New(http.StatusBadRequest, CodeValidation, "日期格式错误").WithErrors(FieldError{
+			Field:   dateErr.Field,
+			Rule:    dateErr.Type,
+			Param:   strings.Join(dateErr.Layouts, " | "),
+			Message: fmt.Sprintf("%q 不是有效的 %s，允许的格式: %s", dateErr.Value, dateErr.Type, strings.Join(dateErr.Layouts, ", ")),
+			Code:    "invalid_date",
+		})
+	case errors.As(err, &typeErr):
+		return New(http.StatusBadRequest, CodeInvalidJSON, "JSON 字段类型错误").WithErrors(FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("%s 应为 %s 类型，实际是 %s", typeErr.Field, typeErr.Type, typeErr.Value),
+			Code:    "invalid_type",
+		})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return New(http.StatusBadRequest, CodeInvalidJSON, "请求体不是有效的 JSON").WithCause(err).WithDetails(err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return New(http.StatusNotFound, CodeNotFound, "记录不存在")
+	case errors.As(err, &pgErr):
+		return fromPgError(pgErr)
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, CodeTimeout, "超过服务端处理时限").WithCause(err)
+	case errors.Is(err, context.Canceled):
+		return New(StatusClientClosedRequest, CodeCanceled, "请求已取消").WithCause(err)
+	}
+	return New(http.StatusInternalServerError, CodeInternal, err.Error()).WithCause(err)
+}
+
+// Wrap 和 From 一样分类 err；无法分类的 500 错误改用 message 描述，原始错误放进 details
+func Wrap(err error, message string) *Error {
+	e := From(err)
+	if e.Status == http.StatusInternalServerError && e.Code == CodeInternal && e.Details == nil {
+		return New(e.Status, e.Code, message).WithDetails(err.Error()).WithCause(err)
+	}
+	return e
+}
+
+// validationErrors 把 validator 的错误转成字段错误，字段名使用 JSON 名 (见 Install)
+func validationErrors(verrs validator.ValidationErrors) []FieldError {
+	errs := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		code := "invalid_field"
+		if fe.Tag() == "required" {
+			code = "missing_field"
+		}
+		errs = append(errs, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: ruleMessage(fe),
+			Code:    code,
+		})
+	}
+	return errs
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	field, param := fe.Field(), fe.Param()
+	switch fe.Tag() {
+	case "required":
+		return field + " 为必填字段"
+	case "gt":
+		return fmt.Sprintf("%s 必须大于 %s", field, param)
+	case "gte", "min":
+		return fmt.Sprintf("%s 不能小于 %s", field, param)
+	case "lt":
+		return fmt.Sprintf("%s 必须小于 %s", field, param)
+	case "lte", "max":
+		return fmt.Sprintf("%s 不能大于 %s", field, param)
+	case "len":
+		return fmt.Sprintf("%s 的长度必须是 %s", field, param)
+	case "oneof":
+		return fmt.Sprintf("%s 必须是 [%s] 之一", field, param)
+	case "email":
+		return field + " 不是有效的邮箱地址"
+	}
+	return fmt.Sprintf("%s 不满足 %s 规则", field, fe.Tag())
+}
+
+// keyColumnsPattern 从 "Key (name, age)=(x, 1) already exists." 中取出列名
+var keyColumnsPattern = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+func constraintField(pgErr *pgconn.PgError) string {
+	if pgErr.ColumnName != "" {
+		return pgErr.ColumnName
+	}
+	if m := keyColumnsPattern.FindStringSubmatch(pgErr.Detail); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// fromPgError 按 SQLSTATE 分类数据库错误
+func fromPgError(pgErr *pgconn.PgError) *Error {
+	fieldErr := FieldError{
+		Field:   constraintField(pgErr),
+		Param:   pgErr.ConstraintName,
+		Message: pgErr.Message,
+	}
+	if pgErr.Detail != "" {
+		fieldErr.Message += ": " + pgErr.Detail
+	}
+	details := gin.H{"sqlstate": pgErr.Code, "constraint": pgErr.ConstraintName, "table": pgErr.TableName}
+
+	constraint := func(status int, rule, message string) *Error {
+		fieldErr.Rule, fieldErr.Code = rule, rule+"_violation"
+		return New(status, CodeConstraint, message).WithErrors(fieldErr).WithDetails(details).WithCause(pgErr)
+	}
+
+	switch pgErr.Code {
+	case "23505":
+		return constraint(http.StatusConflict, "unique", "数据重复，违反唯一约束")
+	case "23503":
+		return constraint(http.StatusConflict, "foreign_key", "违反外键约束")
+	case "23502":
+		return constraint(http.StatusBadRequest, "not_null", "必填列不能为空")
+	case "23514":
+		return constraint(http.StatusBadRequest, "check", "违反检查约束")
+	case "23P01":
+		return constraint(http.StatusConflict, "exclusion", "违反排他约束")
+	case "22P02", "22007", "22008", "22003", "22001":
+		// 无效的输入格式、日期超出范围、数值溢出、字符串过长
+		fieldErr.Rule, fieldErr.Code = "format", "invalid_field"
+		return New(http.StatusBadRequest, CodeValidation, "字段值无效").WithErrors(fieldErr).WithDetails(details).WithCause(pgErr)
+	case "40001", "40P01":
+		return New(http.StatusConflict, CodeConflict, "事务冲突，请重试").WithDetails(details).WithCause(pgErr)
+	case "55P03":
+		return New(http.StatusLocked, CodeLocked, "资源被锁定").WithDetails(details).WithCause(pgErr)
+	case "57014":
+		return New(http.StatusGatewayTimeout, CodeTimeout, "查询超时被取消").WithDetails(details).WithCause(pgErr)
+	case "42P10":
+		return New(http.StatusBadRequest, CodeBadRequest, "冲突列必须有唯一索引或唯一约束").WithDetails(details).WithCause(pgErr)
+	case "21000":
+		return New(http.StatusBadRequest, CodeBadRequest, "同一条语句中冲突键重复").WithDetails(details).WithCause(pgErr)
+	}
+	return New(http.StatusInternalServerError, CodeInternal, pgErr.Error()).WithDetails(details).WithCause(pgErr)
+}
+
+// Install 让 validator 使用 JSON 字段名，并把 404 / 405 也改成统一格式
+func Install(r *gin.Engine) {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(func(c *gin.Context) {
+		Respond(c, NotFound("接口不存在: %s %s", c.Request.Method, c.Request.URL.Path))
+	})
+	r.NoMethod(func(c *gin.Context) {
+		Respond(c, Newf(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "不支持的请求方法: %s", c.Request.Method))
+	})
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,211 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm-shared/datetypes"
+	"gorm.io/gorm"
+)
+
+type signup struct {
+	Name  string `json:"name" binding:"required"`
+	Age   int    `json:"age" binding:"gt=0,lte=150"`
+	Email string `json:"email,omitempty" binding:"omitempty,email"`
+	Role  string `json:"-" binding:"omitempty,oneof=admin user"`
+}
+
+func validationErr(t *testing.T, v interface{}) error {
+	t.Helper()
+	validate := validator.New()
+	validate.SetTagName("binding")
+	validate.RegisterTagNameFunc(jsonFieldName)
+	err := validate.Struct(v)
+	if err == nil {
+		t.Fatalf("%+v passed validation", v)
+	}
+	return err
+}
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		fields []string // 字段:规则:错误码
+	}{
+		{
+			name:   "api error passes through",
+			err:    fmt.Errorf("wrapped: %w", NotFound("用户 %d 不存在", 1)),
+			status: http.StatusNotFound,
+			code:   CodeNotFound,
+		},
+		{
+			name:   "validation",
+			err:    validationErr(t, signup{Age: 200, Email: "x"}),
+			status: http.StatusBadRequest,
+			code:   CodeValidation,
+			fields: []string{"name:required:missing_field", "age:lte:invalid_field", "email:email:invalid_field"},
+		},
+		{
+			name:   "slice validation",
+			err:    binding.SliceValidationError{nil, validationErr(t, signup{Name: "a"})},
+			status: http.StatusBadRequest,
+			code:   CodeValidation,
+			fields: []string{"age:gt:invalid_field"},
+		},
+		{
+			name:   "date",
+			err:    &datetypes.ParseError{Type: "date", Value: "x", Layouts: []string{"2006-01-02"}, Field: "birthday"},
+			status: http.StatusBadRequest,
+			code:   CodeValidation,
+			fields: []string{"birthday:date:invalid_date"},
+		},
+		{
+			name:   "json type",
+			err:    &json.UnmarshalTypeError{Value: "string", Type: reflect.TypeOf(0), Field: "age"},
+			status: http.StatusBadRequest,
+			code:   CodeInvalidJSON,
+			fields: []string{"age:type:invalid_type"},
+		},
+		{"json syntax", json.Unmarshal([]byte("{"), &signup{}), http.StatusBadRequest, CodeInvalidJSON, nil},
+		{"empty body", io.EOF, http.StatusBadRequest, CodeInvalidJSON, nil},
+		{"not found", fmt.Errorf("load: %w", gorm.ErrRecordNotFound), http.StatusNotFound, CodeNotFound, nil},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout, nil},
+		{"canceled", context.Canceled, StatusClientClosedRequest, CodeCanceled, nil},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, CodeInternal, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Status != tt.status || e.Code != tt.code {
+				t.Errorf("From = %d %s, want %d %s", e.Status, e.Code, tt.status, tt.code)
+			}
+			var fields []string
+			for _, fe := range e.Errors {
+				fields = append(fields, fe.Field+":"+fe.Rule+":"+fe.Code)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("errors = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestFromPgError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *pgconn.PgError
+		status int
+		code   string
+		field  string
+		rule   string
+	}{
+		{
+			name:   "unique from detail",
+			err:    &pgconn.PgError{Code: "23505", ConstraintName: "idx_users_name_live", Detail: "Key (name)=(马云) already exists."},
+			status: http.StatusConflict, code: CodeConstraint, field: "name", rule: "unique",
+		},
+		{
+			name:   "composite unique",
+			err:    &pgconn.PgError{Code: "23505", Detail: "Key (user_id, language_id)=(1, 2) already exists."},
+			status: http.StatusConflict, code: CodeConstraint, field: "user_id, language_id", rule: "unique",
+		},
+		{
+			name:   "foreign key",
+			err:    &pgconn.PgError{Code: "23503", Detail: `Key (user_id)=(9) is not present in table "users".`},
+			status: http.StatusConflict, code: CodeConstraint, field: "user_id", rule: "foreign_key",
+		},
+		{
+			name:   "not null uses column name",
+			err:    &pgconn.PgError{Code: "23502", ColumnName: "name"},
+			status: http.StatusBadRequest, code: CodeConstraint, field: "name", rule: "not_null",
+		},
+		{
+			name:   "check",
+			err:    &pgconn.PgError{Code: "23514", ConstraintName: "chk_users_age"},
+			status: http.StatusBadRequest, code: CodeConstraint, rule: "check",
+		},
+		{
+			name:   "exclusion",
+			err:    &pgconn.PgError{Code: "23P01"},
+			status: http.StatusConflict, code: CodeConstraint, rule: "exclusion",
+		},
+		{
+			name:   "string too long",
+			err:    &pgconn.PgError{Code: "22001"},
+			status: http.StatusBadRequest, code: CodeValidation, rule: "format",
+		},
+		{"serialization", &pgconn.PgError{Code: "40001"}, http.StatusConflict, CodeConflict, "", ""},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, http.StatusConflict, CodeConflict, "", ""},
+		{"lock not available", &pgconn.PgError{Code: "55P03"}, http.StatusLocked, CodeLocked, "", ""},
+		{"statement timeout", &pgconn.PgError{Code: "57014"}, http.StatusGatewayTimeout, CodeTimeout, "", ""},
+		{"no conflict target", &pgconn.PgError{Code: "42P10"}, http.StatusBadRequest, CodeBadRequest, "", ""},
+		{"cardinality", &pgconn.PgError{Code: "21000"}, http.StatusBadRequest, CodeBadRequest, "", ""},
+		{"other", &pgconn.PgError{Code: "42P01", Message: `relation "x" does not exist`}, http.StatusInternalServerError, CodeInternal, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(fmt.Errorf("create: %w", tt.err))
+			if e.Status != tt.status || e.Code != tt.code {
+				t.Errorf("From = %d %s, want %d %s", e.Status, e.Code, tt.status, tt.code)
+			}
+			if !errors.Is(e, tt.err) {
+				t.Error("cause not kept")
+			}
+			if tt.rule == "" {
+				if len(e.Errors) != 0 {
+					t.Errorf("errors = %+v, want none", e.Errors)
+				}
+				return
+			}
+			if len(e.Errors) != 1 || e.Errors[0].Field != tt.field || e.Errors[0].Rule != tt.rule {
+				t.Errorf("errors = %+v, want %s:%s", e.Errors, tt.field, tt.rule)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	e := Wrap(errors.New("dial tcp: connection refused"), "查询失败")
+	if e.Message != "查询失败" || e.Details != "dial tcp: connection refused" || e.Status != http.StatusInternalServerError {
+		t.Errorf("Wrap(unknown) = %+v", e.Response)
+	}
+	// 能分类的错误保持 From 的结果
+	e = Wrap(gorm.ErrRecordNotFound, "查询失败")
+	if e.Status != http.StatusNotFound || e.Message != "记录不存在" {
+		t.Errorf("Wrap(not found) = %+v", e.Response)
+	}
+}
+
+func TestRuleMessage(t *testing.T) {
+	err := validationErr(t, struct {
+		A string `json:"a" binding:"len=2"`
+		B string `json:"b" binding:"oneof=x y"`
+		C int    `json:"c" binding:"min=3"`
+		D string `json:"d" binding:"uuid"`
+	}{A: "abc", B: "z", C: 1, D: "x"})
+	want := []string{
+		"a 的长度必须是 2",
+		"b 必须是 [x y] 之一",
+		"c 不能小于 3",
+		"d 不满足 uuid 规则",
+	}
+	var got []string
+	for _, fe := range validationErrors(err.(validator.ValidationErrors)) {
+		got = append(got, fe.Message)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/datetypes"
+)
+
+// BindJSON 和 c.ShouldBindJSON 相同，日期解析失败时补上出错的字段路径 (如 birthday、[0].birthday、period.lower)
+//
+// datetypes 的 UnmarshalJSON 只能看到字段的值，encoding/json 也不会给自定义类型的错误加上字段名，
+// 所以保留请求体，出错时在请求体里查找这个值
+func BindJSON(c *gin.Context, obj interface{}) error {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(c.Request.Body); err != nil {
+			return err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	err := c.ShouldBindJSON(obj)
+	var dateErr *datetypes.ParseError
+	if errors.As(err, &dateErr) && dateErr.Field == "" {
+		dateErr.Field = locateValue(body, dateErr.Value)
+	}
+	return err
+}
+
+// locateValue 返回 JSON 里第一个等于 value 的字符串的路径；没有时找包含 value 的字符串 (如区间里的一端)，都找不到时为空
+func locateValue(body []byte, value string) string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return ""
+	}
+	if path, ok := findString(doc, "", func(s string) bool { return s == value }); ok {
+		return path
+	}
+	path, _ := findString(doc, "", func(s string) bool { return strings.Contains(s, value) })
+	return path
+}
+
+// findString 深度优先查找，对象的键按字典序遍历，保证结果稳定
+func findString(v interface{}, path string, match func(string) bool) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return path, match(v)
+	case []interface{}:
+		for i, item := range v {
+			if p, ok := findString(item, path+"["+strconv.Itoa(i)+"]", match); ok {
+				return p, true
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if p, ok := findString(v[k], p, match); ok {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
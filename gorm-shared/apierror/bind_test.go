@@ -0,0 +1,78 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/datetypes"
+)
+
+func TestLocateValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		value string
+		want  string
+	}{
+		{"top level", `{"name":"a","birthday":"x"}`, "x", "birthday"},
+		{"nested", `{"period":{"lower":"2024-01-01","upper":"bad"}}`, "bad", "period.upper"},
+		{"batch", `[{"birthday":"2024-01-01"},{"birthday":"2024-13-01"}]`, "2024-13-01", "[1].birthday"},
+		{"exact match preferred", `{"a":"bad date","b":"bad"}`, "bad", "b"},
+		{"contained in range text", `{"period":"[2024-01-01,2024-13-01)"}`, "2024-13-01", "period"},
+		{"keys in order", `{"z":"x","a":{"b":["y","x"]}}`, "x", "a.b[1]"},
+		{"not found", `{"name":"a"}`, "x", ""},
+		{"invalid json", `{`, "x", ""},
+	}
+	for _, tt := range tests {
+		if got := locateValue([]byte(tt.body), tt.value); got != tt.want {
+			t.Errorf("%s: locateValue(%s, %q) = %q, want %q", tt.name, tt.body, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBindJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	type user struct {
+		Name     string         `json:"name" binding:"required"`
+		Birthday datetypes.Date `json:"birthday"`
+	}
+	tests := []struct {
+		name  string
+		body  string
+		batch bool
+		field string // 日期错误的字段，空表示没有日期错误
+	}{
+		{name: "ok", body: `{"name":"a","birthday":"2024-01-01"}`},
+		{name: "invalid date", body: `{"name":"a","birthday":"2024-02-30"}`, field: "birthday"},
+		{name: "invalid date in batch", body: `[{"name":"a"},{"name":"b","birthday":"x"}]`, batch: true, field: "[1].birthday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			var err error
+			if tt.batch {
+				err = BindJSON(c, &[]user{})
+			} else {
+				err = BindJSON(c, &user{})
+			}
+
+			var dateErr *datetypes.ParseError
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("BindJSON: %v", err)
+				}
+			} else if !errors.As(err, &dateErr) || dateErr.Field != tt.field {
+				t.Fatalf("BindJSON error = %#v, want date error on %q", err, tt.field)
+			} else if got := From(err).Errors[0].Field; got != tt.field {
+				t.Errorf("FieldError.Field = %q, want %q", got, tt.field)
+			}
+		})
+	}
+}
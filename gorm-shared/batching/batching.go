@@ -0,0 +1,180 @@
+// Package batching 根据模型的列数和绑定参数预算计算批量写入的批大小。
+//
+// Postgres 扩展协议一条语句最多 65535 个绑定参数，多行 INSERT 的参数个数是 行数 × 列数，
+// 固定的 CreateBatchSize 对宽表会超限，对窄表又太保守。
+package batching
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// MaxBindParams Postgres 扩展协议单条语句的绑定参数上限
+const MaxBindParams = 65535
+
+// Options 批量写入的参数
+type Options struct {
+	// Budget 每条语句允许使用的绑定参数个数，默认 MaxBindParams
+	Budget int
+	// MaxRows 每批最多行数，0 表示只受 Budget 限制
+	MaxRows int
+	// Clauses 每一批都附加的子句，如 clause.OnConflict、clause.Returning
+	Clauses []clause.Expression
+}
+
+func (o Options) budget() int {
+	if o.Budget <= 0 || o.Budget > MaxBindParams {
+		return MaxBindParams
+	}
+	return o.Budget
+}
+
+// BatchStat 一批的执行情况
+type BatchStat struct {
+	Index   int    `json:"index"`
+	Offset  int    `json:"offset"`
+	Rows    int    `json:"rows"`
+	Params  int    `json:"params"` // 按列数估算的绑定参数个数
+	Elapsed string `json:"elapsed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report 整个批量写入的结果
+type Report struct {
+	Table     string      `json:"table"`
+	Columns   int         `json:"columns"`
+	BatchSize int         `json:"batch_size"` // 初始批大小，参数超限时会减半
+	Rows      int64       `json:"rows"`
+	Elapsed   string      `json:"elapsed"`
+	Batches   []BatchStat `json:"batches"`
+}
+
+// Columns 一行插入时最多绑定的参数个数，即可写入的列数
+func Columns(sch *schema.Schema) int {
+	n := 0
+	for _, field := range sch.Fields {
+		if field.DBName != "" && field.Creatable {
+			n++
+		}
+	}
+	return n
+}
+
+// sizeFor 在参数预算内每批最多能放多少行
+func sizeFor(columns int, opts Options) int {
+	if columns == 0 {
+		columns = 1
+	}
+	size := opts.budget() / columns
+	if opts.MaxRows > 0 && size > opts.MaxRows {
+		size = opts.MaxRows
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// Size 按 db 的命名策略解析 model，返回批大小
+func Size(db *gorm.DB, model interface{}, opts Options) (int, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return 0, err
+	}
+	return sizeFor(Columns(stmt.Schema), opts), nil
+}
+
+// SizeFor 用默认命名策略解析 models，返回最宽的模型也不会超过预算的批大小
+// 用来在 gorm.Open 之前设置 gorm.Config.CreateBatchSize
+func SizeFor(budget int, models ...interface{}) int {
+	cache := &sync.Map{}
+	size := 0
+	for _, model := range models {
+		sch, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			panic(fmt.Sprintf("batching: parse %T: %v", model, err))
+		}
+		if s := sizeFor(Columns(sch), Options{Budget: budget}); size == 0 || s < size {
+			size = s
+		}
+	}
+	return size
+}
+
+// isTooManyParams 驱动或服务端因为参数个数超限拒绝了语句
+func isTooManyParams(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "limited to 65535 parameters") || strings.Contains(msg, "more than 65535 arguments")
+}
+
+// Create 把 values (切片或切片指针) 按计算出的批大小分批插入，整个过程在一个事务中
+// 某一批因参数超限失败时 (例如自定义子句带了额外参数)，把这一批减半后重试
+func Create(db *gorm.DB, values interface{}, opts Options) (Report, error) {
+	start := time.Now()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(values); err != nil {
+		return Report{}, err
+	}
+	columns := Columns(stmt.Schema)
+	size := sizeFor(columns, opts)
+	report := Report{Table: stmt.Schema.Table, Columns: columns, BatchSize: size, Batches: []BatchStat{}}
+
+	rv := reflect.Indirect(reflect.ValueOf(values))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return report, fmt.Errorf("batching: values must be a slice, got %T", values)
+	}
+	n := rv.Len()
+	if n == 0 {
+		return report, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for offset := 0; offset < n; {
+			end := offset + size
+			if end > n {
+				end = n
+			}
+			batchStart := time.Now()
+			// 设置成本批大小，避免 gorm.Config.CreateBatchSize 再次拆分
+			result := tx.Session(&gorm.Session{CreateBatchSize: end - offset}).
+				Clauses(opts.Clauses...).
+				Create(rv.Slice(offset, end).Interface())
+
+			stat := BatchStat{
+				Index:   len(report.Batches),
+				Offset:  offset,
+				Rows:    end - offset,
+				Params:  (end - offset) * columns,
+				Elapsed: time.Since(batchStart).String(),
+			}
+			if result.Error != nil {
+				stat.Error = result.Error.Error()
+			}
+			report.Batches = append(report.Batches, stat)
+
+			if isTooManyParams(result.Error) && end-offset > 1 {
+				// 参数检查发生在发送之前，事务仍然可用
+				size = (end - offset) / 2
+				continue
+			}
+			if result.Error != nil {
+				return result.Error
+			}
+			report.Rows += result.RowsAffected
+			offset = end
+		}
+		return nil
+	})
+	report.Elapsed = time.Since(start).String()
+	return report, err
+}
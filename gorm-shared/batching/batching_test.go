@@ -0,0 +1,97 @@
+package batching
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm-shared/internal/gormtest"
+	"gorm.io/gorm"
+)
+
+type narrow struct {
+	ID   uint
+	Name string
+}
+
+type pet struct {
+	ID     uint
+	WideID uint
+}
+
+type wide struct {
+	gorm.Model // ID、CreatedAt、UpdatedAt、DeletedAt
+	Name       string
+	Age        int
+	Birthday   time.Time
+	ReadOnly   string `gorm:"<-:false"`
+	Ignored    string `gorm:"-"`
+	Pets       []pet
+}
+
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		model interface{}
+		want  int
+	}{
+		{"narrow", &narrow{}, 2},
+		// 只读、忽略的字段和关联不占参数
+		{"wide", &wide{}, 7},
+	}
+	db := gormtest.DB(t)
+	for _, tt := range tests {
+		if got := Columns(gormtest.Schema(t, db, tt.model)); got != tt.want {
+			t.Errorf("Columns(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSizeFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns int
+		opts    Options
+		want    int
+	}{
+		{"default budget", 7, Options{}, 65535 / 7},
+		{"budget over limit", 7, Options{Budget: 100000}, 65535 / 7},
+		{"custom budget", 10, Options{Budget: 1000}, 100},
+		{"max rows", 2, Options{MaxRows: 500}, 500},
+		{"max rows above budget", 10, Options{Budget: 1000, MaxRows: 5000}, 100},
+		{"wider than budget", 50, Options{Budget: 20}, 1},
+		{"no columns", 0, Options{Budget: 10}, 10},
+	}
+	for _, tt := range tests {
+		if got := sizeFor(tt.columns, tt.opts); got != tt.want {
+			t.Errorf("%s: sizeFor(%d, %+v) = %d, want %d", tt.name, tt.columns, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestSizeForModels(t *testing.T) {
+	// 取最宽的模型
+	if got, want := SizeFor(700, &narrow{}, &wide{}), 100; got != want {
+		t.Errorf("SizeFor = %d, want %d", got, want)
+	}
+	if got, want := SizeFor(0, &narrow{}), 65535/2; got != want {
+		t.Errorf("SizeFor default budget = %d, want %d", got, want)
+	}
+}
+
+func TestIsTooManyParams(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("extended protocol limited to 65535 parameters"), true},
+		{errors.New("bind message has more than 65535 arguments"), true},
+		{errors.New(`duplicate key value violates unique constraint "users_pkey"`), false},
+	}
+	for _, tt := range tests {
+		if got := isTooManyParams(tt.err); got != tt.want {
+			t.Errorf("isTooManyParams(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
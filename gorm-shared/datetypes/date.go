@@ -0,0 +1,198 @@
+package datetypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Date 不带时间的日历日期，对应 Postgres 的 date
+// 内部保存为 Location 时区当天 0 点，零值表示未设置
+type Date time.Time
+
+// NewDate 构造 Location 时区的日期
+func NewDate(year int, month time.Month, day int) Date {
+	return Date(time.Date(year, month, day, 0, 0, 0, 0, Location))
+}
+
+// DateOf 取时间点 t 在 Location 时区的日期
+func DateOf(t time.Time) Date {
+	if t.IsZero() {
+		return Date{}
+	}
+	return NewDate(civilDate(t))
+}
+
+// Today Location 时区的今天
+func Today() Date {
+	return DateOf(time.Now())
+}
+
+// ParseDate 按 Layouts 中的格式依次尝试解析
+func ParseDate(s string) (Date, error) {
+	for _, layout := range Layouts {
+		t, err := time.ParseInLocation(layout, s, Location)
+		if err != nil {
+			continue
+		}
+		if hasZone(layout) {
+			t = t.In(Location)
+		}
+		return NewDate(t.Date()), nil
+	}
+	return Date{}, &ParseError{Type: "date", Value: s, Layouts: Layouts}
+}
+
+// Time 当天 0 点 (Location 时区)
+func (d Date) Time() time.Time {
+	return time.Time(d)
+}
+
+func (d Date) IsZero() bool {
+	return time.Time(d).IsZero()
+}
+
+func (d Date) String() string {
+	return time.Time(d).Format(DateLayout)
+}
+
+func (d Date) AddDays(n int) Date {
+	return NewDate(d.Time().AddDate(0, 0, n).Date())
+}
+
+func (d Date) Before(other Date) bool {
+	return d.Time().Before(other.Time())
+}
+
+func (d Date) After(other Date) bool {
+	return d.Time().After(other.Time())
+}
+
+func (d Date) Equal(other Date) bool {
+	return d.String() == other.String()
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON null 不做任何修改，与 encoding/json 对其他类型的处理一致
+func (d *Date) UnmarshalJSON(b []byte) error {
+	s, ok, err := unquoteJSON("date", b)
+	if err != nil || !ok {
+		return err
+	}
+	date, err := ParseDate(s)
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText 让 Date 可以直接用于 gin 的 query / form 绑定
+func (d *Date) UnmarshalText(b []byte) error {
+	date, err := ParseDate(string(b))
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+func (Date) GormDataType() string {
+	return "date"
+}
+
+// Value 以 "2006-01-02" 字符串写入，避免驱动按 time.Time 的时区换算日期
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Date) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*d = DateOf(v)
+		return nil
+	case nil:
+		return fmt.Errorf("%w: NULL cannot be scanned into Date, use NullDate", ErrInvalid)
+	}
+	if s, ok := scanString(value); ok {
+		date, err := ParseDate(s)
+		if err != nil {
+			return err
+		}
+		*d = date
+		return nil
+	}
+	return fmt.Errorf("%w: cannot scan %T into Date", ErrInvalid, value)
+}
+
+// NullDate 可以为 NULL 的日期，JSON 中对应 null
+type NullDate struct {
+	Date  Date
+	Valid bool
+}
+
+func NewNullDate(d Date) NullDate {
+	return NullDate{Date: d, Valid: true}
+}
+
+func (n NullDate) String() string {
+	if !n.Valid {
+		return ""
+	}
+	return n.Date.String()
+}
+
+func (n NullDate) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return n.Date.MarshalJSON()
+}
+
+func (n *NullDate) UnmarshalJSON(b []byte) error {
+	s, ok, err := unquoteJSON("date", b)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		*n = NullDate{}
+		return nil
+	}
+	date, err := ParseDate(s)
+	if err != nil {
+		return err
+	}
+	*n = NewNullDate(date)
+	return nil
+}
+
+func (NullDate) GormDataType() string {
+	return "date"
+}
+
+func (n NullDate) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Date.Value()
+}
+
+func (n *NullDate) Scan(value interface{}) error {
+	if value == nil {
+		*n = NullDate{}
+		return nil
+	}
+	if err := n.Date.Scan(value); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
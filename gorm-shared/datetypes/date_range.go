@@ -0,0 +1,183 @@
+package datetypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DateRange 对应 Postgres 的 daterange
+// 与 Postgres 的规范形式一致：下界包含、上界不包含，即 [Lower, Upper)
+// Lower / Upper 无效 (Valid 为 false) 表示该方向无界
+type DateRange struct {
+	Lower NullDate
+	Upper NullDate
+	Empty bool
+}
+
+// NewDateRange 构造 [lower, upper) 区间
+func NewDateRange(lower, upper Date) DateRange {
+	return DateRange{Lower: NewNullDate(lower), Upper: NewNullDate(upper)}
+}
+
+// ParseDateRange 解析 Postgres 的区间文本，如 "[2024-01-01,2024-02-01)"、"(,2024-02-01]"、"empty"
+// 包含上界或不包含下界的写法会被规范化成 [) 形式
+func ParseDateRange(s string) (DateRange, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "empty") {
+		return DateRange{Empty: true}, nil
+	}
+	invalid := &ParseError{Type: "daterange", Value: s, Layouts: []string{"[lower,upper)", "empty"}}
+	if len(s) < 3 {
+		return DateRange{}, invalid
+	}
+	left, body, right := s[0], s[1:len(s)-1], s[len(s)-1]
+	if (left != '[' && left != '(') || (right != ']' && right != ')') {
+		return DateRange{}, invalid
+	}
+	lowerStr, upperStr, ok := strings.Cut(body, ",")
+	if !ok {
+		return DateRange{}, invalid
+	}
+
+	var r DateRange
+	bound := func(s string) (NullDate, error) {
+		s = strings.Trim(strings.TrimSpace(s), `"`)
+		if s == "" || strings.EqualFold(s, "infinity") || strings.EqualFold(s, "-infinity") {
+			return NullDate{}, nil
+		}
+		d, err := ParseDate(s)
+		if err != nil {
+			return NullDate{}, err
+		}
+		return NewNullDate(d), nil
+	}
+	var err error
+	if r.Lower, err = bound(lowerStr); err != nil {
+		return DateRange{}, err
+	}
+	if r.Upper, err = bound(upperStr); err != nil {
+		return DateRange{}, err
+	}
+	if left == '(' && r.Lower.Valid {
+		r.Lower.Date = r.Lower.Date.AddDays(1)
+	}
+	if right == ']' && r.Upper.Valid {
+		r.Upper.Date = r.Upper.Date.AddDays(1)
+	}
+	if r.Lower.Valid && r.Upper.Valid && !r.Lower.Date.Before(r.Upper.Date) {
+		return DateRange{Empty: true}, nil
+	}
+	return r, nil
+}
+
+// Contains d 是否在区间内
+func (r DateRange) Contains(d Date) bool {
+	if r.Empty {
+		return false
+	}
+	if r.Lower.Valid && d.Before(r.Lower.Date) {
+		return false
+	}
+	if r.Upper.Valid && !d.Before(r.Upper.Date) {
+		return false
+	}
+	return true
+}
+
+// Days 区间包含的天数，无界区间返回 -1
+func (r DateRange) Days() int {
+	if r.Empty {
+		return 0
+	}
+	if !r.Lower.Valid || !r.Upper.Valid {
+		return -1
+	}
+	return int(r.Upper.Date.Time().Sub(r.Lower.Date.Time()).Hours()+12) / 24
+}
+
+// String Postgres 区间文本
+func (r DateRange) String() string {
+	if r.Empty {
+		return "empty"
+	}
+	var b strings.Builder
+	if r.Lower.Valid {
+		b.WriteByte('[')
+		b.WriteString(r.Lower.Date.String())
+	} else {
+		b.WriteByte('(')
+	}
+	b.WriteByte(',')
+	if r.Upper.Valid {
+		b.WriteString(r.Upper.Date.String())
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// dateRangeJSON {"lower": "2024-01-01", "upper": "2024-02-01"}，null 表示无界
+type dateRangeJSON struct {
+	Lower NullDate `json:"lower"`
+	Upper NullDate `json:"upper"`
+	Empty bool     `json:"empty,omitempty"`
+}
+
+func (r DateRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dateRangeJSON{Lower: r.Lower, Upper: r.Upper, Empty: r.Empty})
+}
+
+// UnmarshalJSON 同时接受对象形式和 Postgres 区间文本
+func (r *DateRange) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		s, _, err := unquoteJSON("daterange", b)
+		if err != nil {
+			return err
+		}
+		v, err := ParseDateRange(s)
+		if err != nil {
+			return err
+		}
+		*r = v
+		return nil
+	}
+
+	var v dateRangeJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Empty {
+		*r = DateRange{Empty: true}
+		return nil
+	}
+	if v.Lower.Valid && v.Upper.Valid && !v.Lower.Date.Before(v.Upper.Date) {
+		return fmt.Errorf("%w: daterange lower %s must be before upper %s", ErrInvalid, v.Lower.Date, v.Upper.Date)
+	}
+	*r = DateRange{Lower: v.Lower, Upper: v.Upper}
+	return nil
+}
+
+func (DateRange) GormDataType() string {
+	return "daterange"
+}
+
+func (r DateRange) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *DateRange) Scan(value interface{}) error {
+	s, ok := scanString(value)
+	if !ok {
+		return fmt.Errorf("%w: cannot scan %T into DateRange", ErrInvalid, value)
+	}
+	v, err := ParseDateRange(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
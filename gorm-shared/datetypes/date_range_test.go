@@ -0,0 +1,120 @@
+package datetypes
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // 规范化后的区间文本
+		days    int
+		wantErr bool
+	}{
+		{"[2024-01-01,2024-02-01)", "[2024-01-01,2024-02-01)", 31, false},
+		{"[2024-01-01,2024-01-31]", "[2024-01-01,2024-02-01)", 31, false},
+		{"(2023-12-31,2024-02-01)", "[2024-01-01,2024-02-01)", 31, false},
+		{` ["2024-01-01","2024-01-02") `, "[2024-01-01,2024-01-02)", 1, false},
+		{"(,2024-02-01)", "(,2024-02-01)", -1, false},
+		{"[2024-01-01,infinity)", "[2024-01-01,)", -1, false},
+		{"[-infinity,)", "(,)", -1, false},
+		{"empty", "empty", 0, false},
+		{"EMPTY", "empty", 0, false},
+		{"[2024-01-01,2024-01-01)", "empty", 0, false},
+		{"[2024-02-01,2024-01-01)", "empty", 0, false},
+		{"(2024-01-01,2024-01-02)", "empty", 0, false},
+		{"2024-01-01,2024-02-01", "", 0, true},
+		{"[2024-01-01;2024-02-01)", "", 0, true},
+		{"[2024-01-01,2024-13-01)", "", 0, true},
+		{"[]", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDateRange(tt.in)
+			if tt.wantErr {
+				var perr *ParseError
+				if !errors.As(err, &perr) {
+					t.Fatalf("ParseDateRange(%q) error = %v, want *ParseError", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseDateRange(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+			if got.Days() != tt.days {
+				t.Errorf("Days() = %d, want %d", got.Days(), tt.days)
+			}
+		})
+	}
+}
+
+func TestDateRangeContains(t *testing.T) {
+	r := NewDateRange(NewDate(2024, 1, 1), NewDate(2024, 2, 1))
+	upperOnly := DateRange{Upper: NewNullDate(NewDate(2024, 2, 1))}
+	tests := []struct {
+		r    DateRange
+		d    Date
+		want bool
+	}{
+		{r, NewDate(2023, 12, 31), false},
+		{r, NewDate(2024, 1, 1), true},
+		{r, NewDate(2024, 1, 31), true},
+		{r, NewDate(2024, 2, 1), false},
+		{upperOnly, NewDate(1900, 1, 1), true},
+		{upperOnly, NewDate(2024, 2, 1), false},
+		{DateRange{}, NewDate(2024, 1, 1), true},
+		{DateRange{Empty: true}, NewDate(2024, 1, 1), false},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Contains(tt.d); got != tt.want {
+			t.Errorf("%s.Contains(%s) = %v, want %v", tt.r, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestDateRangeJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{`{"lower":"2024-01-01","upper":"2024-02-01"}`, "[2024-01-01,2024-02-01)", false},
+		{`{"lower":null,"upper":"2024-02-01"}`, "(,2024-02-01)", false},
+		{`{"empty":true}`, "empty", false},
+		{`"[2024-01-01,2024-01-31]"`, "[2024-01-01,2024-02-01)", false},
+		{`{"lower":"2024-02-01","upper":"2024-01-01"}`, "", true},
+		{`{"lower":"2024-02-30"}`, "", true},
+		{`"[2024-01-01"`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var r DateRange
+			err := json.Unmarshal([]byte(tt.in), &r)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Unmarshal error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.String() != tt.want {
+				t.Errorf("Unmarshal = %s, want %s", r, tt.want)
+			}
+		})
+	}
+
+	b, err := json.Marshal(DateRange{Lower: NewNullDate(NewDate(2024, 1, 1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"lower":"2024-01-01","upper":null}`; string(b) != want {
+		t.Errorf("Marshal = %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,191 @@
+package datetypes
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"2024-03-01", "2024-03-01", false},
+		{"2024/03/01", "2024-03-01", false},
+		{"20240301", "2024-03-01", false},
+		// 带时区的时间先换算到 Location (Asia/Shanghai) 再取日期
+		{"2024-02-29T16:00:00Z", "2024-03-01", false},
+		{"2024-02-29T15:59:59Z", "2024-02-29", false},
+		{"2024-03-01T00:30:00+08:00", "2024-03-01", false},
+		{"2023-02-29", "", true},
+		{"2024-3-1", "", true},
+		{"03/01/2024", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDate(tt.in)
+			if tt.wantErr {
+				var perr *ParseError
+				if !errors.As(err, &perr) || !errors.Is(err, ErrInvalid) {
+					t.Fatalf("ParseDate(%q) error = %v, want *ParseError", tt.in, err)
+				}
+				if perr.Type != "date" || perr.Value != tt.in || len(perr.Layouts) != len(Layouts) {
+					t.Errorf("ParseError = %+v", perr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseDate(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+			if got.Time().Location() != Location || got.Time().Hour() != 0 {
+				t.Errorf("ParseDate(%q) = %v, want midnight in %v", tt.in, got.Time(), Location)
+			}
+		})
+	}
+}
+
+func TestDateOf(t *testing.T) {
+	tests := []struct {
+		name string
+		in   time.Time
+		want string
+	}{
+		// 驱动扫描出的 UTC 时间直接取墙上日期
+		{"utc wall date", time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), "2024-03-01"},
+		{"other zone", time.Date(2024, 3, 1, 23, 0, 0, 0, time.FixedZone("", -5*3600)), "2024-03-02"},
+		{"location", time.Date(2024, 3, 1, 0, 0, 0, 0, Location), "2024-03-01"},
+	}
+	for _, tt := range tests {
+		if got := DateOf(tt.in).String(); got != tt.want {
+			t.Errorf("%s: DateOf(%v) = %s, want %s", tt.name, tt.in, got, tt.want)
+		}
+	}
+	if !DateOf(time.Time{}).IsZero() {
+		t.Error("DateOf(zero) is not zero")
+	}
+}
+
+func TestDateArithmetic(t *testing.T) {
+	d := NewDate(2024, 2, 28)
+	tests := []struct {
+		days int
+		want string
+	}{
+		{0, "2024-02-28"},
+		{1, "2024-02-29"},
+		{2, "2024-03-01"},
+		{-59, "2023-12-31"},
+		{366, "2025-02-28"},
+	}
+	for _, tt := range tests {
+		if got := d.AddDays(tt.days).String(); got != tt.want {
+			t.Errorf("AddDays(%d) = %s, want %s", tt.days, got, tt.want)
+		}
+	}
+	next := d.AddDays(1)
+	if !d.Before(next) || !next.After(d) || d.Equal(next) || !d.Equal(NewDate(2024, 2, 28)) {
+		t.Error("Before / After / Equal")
+	}
+}
+
+func TestDateScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      interface{}
+		want    string
+		wantErr bool
+	}{
+		{"time", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "2024-03-01", false},
+		{"string", "2024-03-01", "2024-03-01", false},
+		{"bytes", []byte("2024-03-01"), "2024-03-01", false},
+		{"bad string", "yesterday", "", true},
+		{"null", nil, "", true},
+		{"int", int64(20240301), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Date
+			err := d.Scan(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Scan(%#v) error = %v, want ErrInvalid", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.String() != tt.want {
+				t.Errorf("Scan(%#v) = %s, want %s", tt.in, d, tt.want)
+			}
+			if v, _ := d.Value(); v != tt.want {
+				t.Errorf("Value() = %#v, want %q", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateJSON(t *testing.T) {
+	type payload struct {
+		Birthday Date     `json:"birthday"`
+		Expires  NullDate `json:"expires"`
+	}
+	tests := []struct {
+		in      string
+		want    payload
+		wantErr bool
+	}{
+		{`{"birthday":"2024-03-01","expires":"20250101"}`, payload{NewDate(2024, 3, 1), NewNullDate(NewDate(2025, 1, 1))}, false},
+		{`{"birthday":null,"expires":null}`, payload{}, false},
+		{`{}`, payload{}, false},
+		{`{"birthday":"2024-13-01"}`, payload{}, true},
+		{`{"birthday":20240301}`, payload{}, true},
+		{`{"expires":"x"}`, payload{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got payload
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Unmarshal error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Birthday.Equal(tt.want.Birthday) || got.Expires.Valid != tt.want.Expires.Valid || got.Expires.String() != tt.want.Expires.String() {
+				t.Errorf("Unmarshal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	b, err := json.Marshal(payload{Birthday: NewDate(2024, 3, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"birthday":"2024-03-01","expires":null}`; string(b) != want {
+		t.Errorf("Marshal = %s, want %s", b, want)
+	}
+}
+
+func TestNullDateScan(t *testing.T) {
+	var n NullDate
+	if err := n.Scan(nil); err != nil || n.Valid {
+		t.Errorf("Scan(nil) = %+v, %v", n, err)
+	}
+	if v, _ := n.Value(); v != nil {
+		t.Errorf("Value() = %#v, want nil", v)
+	}
+	if err := n.Scan("2024-03-01"); err != nil || !n.Valid || n.String() != "2024-03-01" {
+		t.Errorf("Scan(string) = %+v, %v", n, err)
+	}
+}
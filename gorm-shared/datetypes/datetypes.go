@@ -0,0 +1,97 @@
+// Package datetypes 提供可以直接用在 GORM 模型和 JSON 里的日期/时间值类型：
+// Date (date)、NullDate、TimeOfDay (time) 和 DateRange (daterange)。
+//
+// 所有类型都能从 time.Time、string 和 []byte 扫描，写入数据库时统一使用文本格式，
+// 不依赖驱动对 time.Time 的时区换算。
+package datetypes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // 保证没有系统时区数据库的环境也能加载 Asia/Shanghai
+)
+
+const (
+	// DateLayout 输出和写入数据库时使用的日期格式
+	DateLayout = "2006-01-02"
+	// TimeOfDayLayout 输出和写入数据库时使用的时间格式，有纳秒时会带小数部分
+	TimeOfDayLayout = "15:04:05"
+)
+
+var (
+	// Location 日期所在的时区，应当与 DSN 中的 timezone 一致
+	// 带时区的时间 (timestamptz、带偏移量的字符串) 会先换算到这个时区再取日期
+	Location = mustLoadLocation("Asia/Shanghai")
+
+	// Layouts 解析日期字符串时依次尝试的格式，可以在启动时修改
+	Layouts = []string{DateLayout, "2006/01/02", "20060102", time.RFC3339Nano}
+
+	// TimeLayouts 解析时间字符串时依次尝试的格式
+	TimeLayouts = []string{"15:04:05.999999999", "15:04"}
+)
+
+// ErrInvalid 所有解析失败的错误都可以用 errors.Is(err, ErrInvalid) 判断
+var ErrInvalid = errors.New("datetypes: invalid value")
+
+// ParseError 字符串不符合任何一种允许的格式
+type ParseError struct {
+	Type    string   // 目标类型，如 "date"
+	Value   string   // 原始输入
+	Layouts []string // 允许的格式
+	Field   string   // 出错的 JSON 字段路径，解码时不知道，由绑定请求的地方填写 (见 apierror.BindJSON)
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("datetypes: cannot parse %q as %s, expected one of %s", e.Value, e.Type, strings.Join(e.Layouts, ", "))
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// hasZone 格式里是否带时区信息
+func hasZone(layout string) bool {
+	return strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST")
+}
+
+// civilDate 取 t 对应的日历日期
+// 驱动把 date / timestamp 扫描成 UTC 的 time.Time，墙上时间就是数据库里的值，直接取；
+// 其他时区的 time.Time 表示一个时间点，先换算到 Location 再取日期
+func civilDate(t time.Time) (int, time.Month, int) {
+	if t.Location() != time.UTC {
+		t = t.In(Location)
+	}
+	return t.Date()
+}
+
+func scanString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// unquoteJSON 读取 JSON 字符串，null 返回 ok=false
+func unquoteJSON(typ string, b []byte) (s string, ok bool, err error) {
+	if string(b) == "null" {
+		return "", false, nil
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return "", false, fmt.Errorf("%w: %s must be a JSON string, got %s", ErrInvalid, typ, b)
+	}
+	return s, true, nil
+}
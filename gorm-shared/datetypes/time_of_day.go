@@ -0,0 +1,96 @@
+package datetypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TimeOfDay 一天中的时刻 (距离 0 点的时长)，对应 Postgres 的 time (不带时区)
+type TimeOfDay time.Duration
+
+// NewTimeOfDay 构造时刻，超出 [00:00, 24:00) 的部分按天取模
+func NewTimeOfDay(hour, minute, sec, nsec int) TimeOfDay {
+	d := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(nsec)
+	d %= 24 * time.Hour
+	if d < 0 {
+		d += 24 * time.Hour
+	}
+	return TimeOfDay(d)
+}
+
+// TimeOfDayOf 取时间点 t 在 Location 时区的时刻，规则与 DateOf 相同
+func TimeOfDayOf(t time.Time) TimeOfDay {
+	if t.Location() != time.UTC {
+		t = t.In(Location)
+	}
+	return NewTimeOfDay(t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
+}
+
+// ParseTimeOfDay 按 TimeLayouts 中的格式依次尝试解析
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	for _, layout := range TimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return NewTimeOfDay(t.Hour(), t.Minute(), t.Second(), t.Nanosecond()), nil
+		}
+	}
+	return 0, &ParseError{Type: "time", Value: s, Layouts: TimeLayouts}
+}
+
+// On 把时刻放到日期 d 上，得到 Location 时区的时间点
+func (t TimeOfDay) On(d Date) time.Time {
+	return d.Time().Add(time.Duration(t))
+}
+
+func (t TimeOfDay) String() string {
+	return time.Time{}.Add(time.Duration(t)).Format("15:04:05.999999999")
+}
+
+func (t TimeOfDay) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *TimeOfDay) UnmarshalJSON(b []byte) error {
+	s, ok, err := unquoteJSON("time", b)
+	if err != nil || !ok {
+		return err
+	}
+	v, err := ParseTimeOfDay(s)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+func (TimeOfDay) GormDataType() string {
+	return "time"
+}
+
+func (t TimeOfDay) Value() (driver.Value, error) {
+	return t.String(), nil
+}
+
+func (t *TimeOfDay) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*t = TimeOfDayOf(v)
+		return nil
+	case int64:
+		// 部分驱动把 time 扫描成距离 0 点的微秒数
+		*t = TimeOfDay(time.Duration(v) * time.Microsecond)
+		return nil
+	}
+	if s, ok := scanString(value); ok {
+		v, err := ParseTimeOfDay(s)
+		if err != nil {
+			return err
+		}
+		*t = v
+		return nil
+	}
+	return fmt.Errorf("%w: cannot scan %T into TimeOfDay", ErrInvalid, value)
+}
@@ -0,0 +1,100 @@
+package datetypes
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"08:30:00", "08:30:00", false},
+		{"08:30", "08:30:00", false},
+		{"23:59:59.123456", "23:59:59.123456", false},
+		{"00:00:00.000", "00:00:00", false},
+		{"24:00", "", true},
+		{"8.30", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTimeOfDay(tt.in)
+			if tt.wantErr {
+				var perr *ParseError
+				if !errors.As(err, &perr) || perr.Type != "time" {
+					t.Fatalf("ParseTimeOfDay(%q) error = %v, want *ParseError", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseTimeOfDay(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTimeOfDayWraps(t *testing.T) {
+	tests := []struct {
+		hour, minute int
+		want         string
+	}{
+		{25, 0, "01:00:00"},
+		{-1, 30, "23:30:00"},
+		{0, 24 * 60, "00:00:00"},
+	}
+	for _, tt := range tests {
+		if got := NewTimeOfDay(tt.hour, tt.minute, 0, 0).String(); got != tt.want {
+			t.Errorf("NewTimeOfDay(%d, %d) = %s, want %s", tt.hour, tt.minute, got, tt.want)
+		}
+	}
+}
+
+func TestTimeOfDayScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      interface{}
+		want    string
+		wantErr bool
+	}{
+		{"utc time", time.Date(0, 1, 1, 8, 30, 0, 0, time.UTC), "08:30:00", false},
+		{"other zone", time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC).In(time.FixedZone("", 3600)), "08:30:00", false},
+		{"microseconds", int64(8*3600+30*60) * 1e6, "08:30:00", false},
+		{"string", "08:30:00.5", "08:30:00.5", false},
+		{"bytes", []byte("08:30"), "08:30:00", false},
+		{"bad string", "noon", "", true},
+		{"float", 8.5, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got TimeOfDay
+			err := got.Scan(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Scan(%#v) error = %v, want ErrInvalid", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Scan(%#v) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeOfDayOn(t *testing.T) {
+	got := NewTimeOfDay(8, 30, 0, 0).On(NewDate(2024, 3, 1))
+	want := time.Date(2024, 3, 1, 8, 30, 0, 0, Location)
+	if !got.Equal(want) {
+		t.Errorf("On = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,413 @@
+// Package deleteplan 在执行 db.Select("CreditCards").Delete(&user) 之前算出它会删除什么。
+//
+// 计划按 gorm 删除关联的实际行为生成 (callbacks.DeleteBeforeAssociations)：
+//   - has one / has many：按外键 (多态关联再加上类型列) 删除子表的行，模型有 DeletedAt 时是软删除，Unscoped 时是硬删除
+//   - many2many：删除连接表的行；连接表模型有 DeletedAt 时总是软删除，gorm 不会把 Unscoped 传给连接表
+//   - belongs to：不删除
+//   - 只删除一层：子记录按条件删除而不是先查出来，"CreditCards.X" 或 clause.Associations 不会继续删除子记录的关联
+//
+// 硬删除的行会触发数据库外键的 ON DELETE 动作，计划从 pg_constraint 读出引用这些表的外键，
+// 统计 CASCADE 会级联删除、SET NULL / SET DEFAULT 会修改的行数，以及 RESTRICT / NO ACTION 会让删除失败的行数。
+// 软删除只是 UPDATE，不触发外键。
+//
+// Execute 在一个事务里锁住涉及的表，重新生成计划并和传入的计划比较，一致后逐步执行并核对每一步实际影响的行数。
+package deleteplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 步骤类型
+const (
+	TypeRoot      = "root"
+	TypeHasOne    = "has_one"
+	TypeHasMany   = "has_many"
+	TypeMany2Many = "many2many"
+)
+
+// 删除方式
+const (
+	ModeSoft = "soft"
+	ModeHard = "hard"
+)
+
+// Options 计划参数，对应 db.Select(Select...).Delete 和 db.Unscoped()
+type Options struct {
+	// Select 要一起删除的关联名，clause.Associations (或 "*") 表示全部
+	Select []string
+	// Unscoped 硬删除根记录和 has one / has many 子记录
+	Unscoped bool
+	// MaxDepth 外键 CASCADE 最多追踪几层，默认 5
+	MaxDepth int
+}
+
+func (o Options) maxDepth() int {
+	if o.MaxDepth <= 0 {
+		return 5
+	}
+	return o.MaxDepth
+}
+
+// Plan 删除计划
+type Plan struct {
+	Model    string        `json:"model"`
+	Table    string        `json:"table"`
+	Keys     []interface{} `json:"keys"` // 根记录的主键
+	Unscoped bool          `json:"unscoped"`
+	Select   []string      `json:"select,omitempty"`
+	Steps    []Step        `json:"steps"`   // 按执行顺序，根记录在最后
+	Effects  []Effect      `json:"effects"` // 硬删除触发的外键动作
+	Warnings []string      `json:"warnings,omitempty"`
+	WillFail bool          `json:"will_fail"` // 有 RESTRICT / NO ACTION 外键仍被引用，执行会失败
+	Executed bool          `json:"executed"`
+}
+
+// Step 计划中的一次 DELETE (软删除时是 UPDATE ... SET deleted_at)
+type Step struct {
+	Relation    string       `json:"relation,omitempty"` // 关联名，根记录为空
+	Type        string       `json:"type"`
+	Table       string       `json:"table"`
+	Mode        string       `json:"mode"`
+	Rows        int64        `json:"rows"`
+	Polymorphic *Polymorphic `json:"polymorphic,omitempty"`
+	Affected    *int64       `json:"affected,omitempty"` // 执行后实际影响的行数
+
+	model interface{}
+	conds []clause.Expression
+}
+
+// Polymorphic 多态关联的类型条件
+type Polymorphic struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+}
+
+// Effect 一个外键的 ON DELETE 动作
+type Effect struct {
+	Constraint        string   `json:"constraint"`
+	Table             string   `json:"table"` // 引用方
+	Columns           []string `json:"columns"`
+	References        string   `json:"references"` // 被删除行所在的表
+	ReferencedColumns []string `json:"referenced_columns"`
+	Action            string   `json:"action"`  // cascade、set null、set default、restrict、no action
+	Trigger           string   `json:"trigger"` // 触发它的步骤 (表名) 或上一层级联的外键
+	Depth             int      `json:"depth"`   // 1 表示由计划中的步骤直接触发
+	Rows              int64    `json:"rows"`    // 引用被删除行的行数
+	Fails             bool     `json:"fails"`   // RESTRICT / NO ACTION 且仍有引用，删除会失败
+	Affected          *int64   `json:"affected,omitempty"`
+
+	cond clause.Expression
+}
+
+// rowSet 一组被硬删除的行
+type rowSet struct {
+	table   string
+	cond    clause.Expression
+	trigger string
+	depth   int
+}
+
+// foreignKey pg_constraint 里的一个外键
+type foreignKey struct {
+	Name       string
+	Table      string
+	Columns    string
+	RefColumns string
+	Action     string
+}
+
+var actions = map[string]string{
+	"a": "no action",
+	"r": "restrict",
+	"c": "cascade",
+	"n": "set null",
+	"d": "set default",
+}
+
+// Build 生成删除 root 的计划，root 是已经查出来的记录 (结构体指针或切片指针)，和传给 Delete 的值相同
+func Build(db *gorm.DB, root interface{}, opts Options) (*Plan, error) {
+	db = db.Session(&gorm.Session{NewDB: true})
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(root); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	rv := reflect.Indirect(reflect.ValueOf(root))
+
+	plan := &Plan{Model: s.Name, Table: s.Table, Unscoped: opts.Unscoped, Select: opts.Select, Steps: []Step{}, Effects: []Effect{}}
+
+	_, keyValues := schema.GetIdentityFieldValuesMap(ctx, rv, s.PrimaryFields)
+	if len(keyValues) == 0 {
+		return nil, errors.New("deleteplan: 根记录没有主键值")
+	}
+	for _, values := range keyValues {
+		if len(values) == 1 {
+			plan.Keys = append(plan.Keys, values[0])
+		} else {
+			plan.Keys = append(plan.Keys, values)
+		}
+	}
+
+	for _, rel := range selectedRelations(s, opts.Select, plan) {
+		step := Step{Relation: rel.Name, Table: rel.FieldSchema.Table}
+		switch rel.Type {
+		case schema.HasOne, schema.HasMany:
+			step.Type = TypeHasMany
+			if rel.Type == schema.HasOne {
+				step.Type = TypeHasOne
+			}
+			step.model = reflect.New(rel.FieldSchema.ModelType).Interface()
+			step.conds = rel.ToQueryConditions(ctx, rv)
+			step.Mode = mode(rel.FieldSchema, opts.Unscoped)
+			if rel.Polymorphic != nil {
+				step.Polymorphic = &Polymorphic{Column: rel.Polymorphic.PolymorphicType.DBName, Value: rel.Polymorphic.Value}
+			}
+			if hasDeletableRelations(rel.FieldSchema) {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 自己的关联不会被删除，gorm 只删除一层", rel.Name))
+			}
+		case schema.Many2Many:
+			step.Type = TypeMany2Many
+			step.Table = rel.JoinTable.Table
+			step.model = reflect.New(rel.JoinTable.ModelType).Interface()
+			step.conds = many2manyConditions(ctx, rel, rv)
+			// gorm 删除连接表时没有传递 Unscoped
+			step.Mode = mode(rel.JoinTable, false)
+			if opts.Unscoped && step.Mode == ModeSoft {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("Unscoped 不会传给连接表 %s，它的行只会被软删除", step.Table))
+			}
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	column, values := schema.ToQueryValues(s.Table, s.PrimaryFieldDBNames, keyValues)
+	plan.Steps = append(plan.Steps, Step{
+		Type:  TypeRoot,
+		Table: s.Table,
+		Mode:  mode(s, opts.Unscoped),
+		model: root,
+		conds: []clause.Expression{clause.IN{Column: column, Values: values}},
+	})
+
+	for i := range plan.Steps {
+		n, err := plan.Steps[i].count(db)
+		if err != nil {
+			return nil, err
+		}
+		plan.Steps[i].Rows = n
+	}
+
+	if err := plan.buildEffects(db, opts.maxDepth()); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// selectedRelations 按字段顺序返回 Select 选中的 has one / has many / many2many 关联
+func selectedRelations(s *schema.Schema, selects []string, plan *Plan) []*schema.Relationship {
+	selected := map[string]bool{}
+	all := false
+	for _, name := range selects {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == clause.Associations || name == "*":
+			all = true
+		case s.Relationships.Relations[name] != nil:
+			selected[name] = true
+		case strings.Contains(name, "."):
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 不会被删除，gorm 只删除一层关联", name))
+		default:
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 不是 %s 的关联，删除时会被忽略", name, s.Name))
+		}
+	}
+
+	var rels []*schema.Relationship
+	for _, field := range s.Fields {
+		rel := s.Relationships.Relations[field.Name]
+		if rel == nil || !(all || selected[rel.Name]) {
+			continue
+		}
+		if rel.Type == schema.BelongsTo {
+			if selected[rel.Name] {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 是 belongs to 关联，删除时会被忽略", rel.Name))
+			}
+			continue
+		}
+		rels = append(rels, rel)
+	}
+	return rels
+}
+
+// hasDeletableRelations 模型自己有 has one / has many / many2many 关联
+// gorm 解析关联时会在子模型里用 "_User_CreditCards" 这样的键记下反向的关联，不算在内
+func hasDeletableRelations(s *schema.Schema) bool {
+	for name, rel := range s.Relationships.Relations {
+		if rel.Type != schema.BelongsTo && !strings.HasPrefix(name, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// many2manyConditions 与 gorm 删除连接表的条件相同
+func many2manyConditions(ctx context.Context, rel *schema.Relationship, rv reflect.Value) []clause.Expression {
+	var conds []clause.Expression
+	var foreignFields []*schema.Field
+	var relForeignKeys []string
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey {
+			foreignFields = append(foreignFields, ref.PrimaryKey)
+			relForeignKeys = append(relForeignKeys, ref.ForeignKey.DBName)
+		} else if ref.PrimaryValue != "" {
+			conds = append(conds, clause.Eq{
+				Column: clause.Column{Table: rel.JoinTable.Table, Name: ref.ForeignKey.DBName},
+				Value:  ref.PrimaryValue,
+			})
+		}
+	}
+	_, foreignValues := schema.GetIdentityFieldValuesMap(ctx, rv, foreignFields)
+	column, values := schema.ToQueryValues(rel.JoinTable.Table, relForeignKeys, foreignValues)
+	return append(conds, clause.IN{Column: column, Values: values})
+}
+
+// mode 模型有软删除字段 (DeleteClauses 非空) 且不是 Unscoped 时为软删除
+func mode(s *schema.Schema, unscoped bool) string {
+	if len(s.DeleteClauses) > 0 && !unscoped {
+		return ModeSoft
+	}
+	return ModeHard
+}
+
+// query 和 gorm 执行这一步时相同的查询条件；软删除时只包含未删除的行
+func (step *Step) query(db *gorm.DB) *gorm.DB {
+	tx := db.Session(&gorm.Session{NewDB: true}).Model(step.model)
+	if step.Type == TypeMany2Many {
+		tx = tx.Table(step.Table)
+	}
+	if step.Mode == ModeHard {
+		tx = tx.Unscoped()
+	}
+	return tx.Clauses(clause.Where{Exprs: step.conds})
+}
+
+func (step *Step) count(db *gorm.DB) (int64, error) {
+	// gorm 遇到空的 IN 条件时跳过这一步
+	for _, cond := range step.conds {
+		if in, ok := cond.(clause.IN); ok && len(in.Values) == 0 {
+			return 0, nil
+		}
+	}
+	var n int64
+	err := step.query(db).Count(&n).Error
+	return n, err
+}
+
+// buildEffects 找出每一步硬删除触发的外键动作，CASCADE 继续向下追踪
+func (p *Plan) buildEffects(db *gorm.DB, maxDepth int) error {
+	var queue []rowSet
+	for _, step := range p.Steps {
+		if step.Mode == ModeHard && step.Rows > 0 {
+			queue = append(queue, rowSet{table: step.Table, cond: clause.And(step.conds...), trigger: step.Table, depth: 1})
+		}
+	}
+
+	fks := map[string][]foreignKey{}
+	for len(queue) > 0 {
+		set := queue[0]
+		queue = queue[1:]
+
+		refs, ok := fks[set.table]
+		if !ok {
+			var err error
+			if refs, err = referencingKeys(db, set.table); err != nil {
+				return err
+			}
+			fks[set.table] = refs
+		}
+
+		for _, fk := range refs {
+			effect := Effect{
+				Constraint:        fk.Name,
+				Table:             fk.Table,
+				Columns:           strings.Split(fk.Columns, ","),
+				References:        set.table,
+				ReferencedColumns: strings.Split(fk.RefColumns, ","),
+				Action:            actions[fk.Action],
+				Trigger:           set.trigger,
+				Depth:             set.depth,
+			}
+			effect.cond = p.referencingCondition(db, set, effect)
+			if err := db.Session(&gorm.Session{NewDB: true}).Table(effect.Table).
+				Clauses(clause.Where{Exprs: []clause.Expression{effect.cond}}).
+				Count(&effect.Rows).Error; err != nil {
+				return err
+			}
+			if effect.Rows == 0 {
+				continue
+			}
+			if effect.Action == "restrict" || effect.Action == "no action" {
+				effect.Fails = true
+				p.WillFail = true
+			}
+			p.Effects = append(p.Effects, effect)
+
+			if effect.Action == "cascade" {
+				if set.depth >= maxDepth {
+					p.Warnings = append(p.Warnings, fmt.Sprintf("外键 %s 之后的级联超过 %d 层，没有继续统计", fk.Name, maxDepth))
+					continue
+				}
+				queue = append(queue, rowSet{table: effect.Table, cond: effect.cond, trigger: "cascade:" + fk.Name, depth: set.depth + 1})
+			}
+		}
+	}
+	return nil
+}
+
+// referencingCondition effect.Table 中引用 set 里被删除行的行，计划里已经硬删除的行不算
+func (p *Plan) referencingCondition(db *gorm.DB, set rowSet, effect Effect) clause.Expression {
+	quote := db.Statement.Quote
+	columns := make([]string, len(effect.Columns))
+	for i, column := range effect.Columns {
+		columns[i] = quote(clause.Column{Table: effect.Table, Name: column})
+	}
+	parent := db.Session(&gorm.Session{NewDB: true}).Table(set.table).
+		Select(effect.ReferencedColumns).
+		Clauses(clause.Where{Exprs: []clause.Expression{set.cond}})
+
+	exprs := []clause.Expression{clause.Expr{SQL: "(" + strings.Join(columns, ", ") + ") IN (?)", Vars: []interface{}{parent}}}
+	for _, step := range p.Steps {
+		if step.Table == effect.Table && step.Mode == ModeHard && step.Table != set.table {
+			exprs = append(exprs, clause.Not(clause.And(step.conds...)))
+		}
+	}
+	return clause.And(exprs...)
+}
+
+// referencingKeys 引用 table 的外键
+func referencingKeys(db *gorm.DB, table string) ([]foreignKey, error) {
+	var fks []foreignKey
+	err := db.Session(&gorm.Session{NewDB: true}).Raw(`
+		SELECT c.conname AS name, child.relname AS "table", c.confdeltype AS action,
+			(SELECT string_agg(a.attname, ',' ORDER BY k.i)
+				FROM unnest(c.conkey) WITH ORDINALITY k(attnum, i)
+				JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum) AS columns,
+			(SELECT string_agg(a.attname, ',' ORDER BY k.i)
+				FROM unnest(c.confkey) WITH ORDINALITY k(attnum, i)
+				JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum) AS ref_columns
+		FROM pg_constraint c
+		JOIN pg_class child ON child.oid = c.conrelid
+		WHERE c.contype = 'f' AND c.confrelid = ?::regclass
+		ORDER BY c.conname`, table).Scan(&fks).Error
+	return fks, err
+}
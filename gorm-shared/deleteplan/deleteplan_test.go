@@ -0,0 +1,183 @@
+package deleteplan
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm-shared/internal/gormtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type company struct {
+	ID   uint
+	Name string
+}
+
+type card struct {
+	gorm.Model
+	UserID uint
+}
+
+type profile struct {
+	ID     uint
+	UserID uint
+}
+
+type language struct {
+	ID   uint
+	Name string
+}
+
+type user struct {
+	gorm.Model
+	CompanyID uint
+	Company   company
+	Cards     []card
+	Profile   profile
+	Languages []language `gorm:"many2many:user_languages"`
+}
+
+func TestSelectedRelations(t *testing.T) {
+	s := gormtest.Schema(t, gormtest.DB(t), &user{})
+	tests := []struct {
+		name     string
+		selects  []string
+		want     []string
+		warnings int
+	}{
+		{"none", nil, nil, 0},
+		{"field order", []string{"Languages", " Cards "}, []string{"Cards", "Languages"}, 0},
+		{"all", []string{"*"}, []string{"Cards", "Profile", "Languages"}, 0},
+		{"associations", []string{clause.Associations}, []string{"Cards", "Profile", "Languages"}, 0},
+		{"belongs to ignored", []string{"Company", "Profile"}, []string{"Profile"}, 1},
+		{"nested ignored", []string{"Cards.User"}, nil, 1},
+		{"unknown", []string{"Pets", "Cards"}, []string{"Cards"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &Plan{}
+			var got []string
+			for _, rel := range selectedRelations(s, tt.selects, plan) {
+				got = append(got, rel.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relations = %v, want %v", got, tt.want)
+			}
+			if len(plan.Warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", plan.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestHasDeletableRelations(t *testing.T) {
+	tests := []struct {
+		name  string
+		model interface{}
+		want  bool
+	}{
+		{"has many", &user{}, true},
+		{"no relations", &company{}, false},
+		// 解析 user 之后 card 里有反向的 "_user_Cards"，不算 card 自己的关联
+		{"back reference only", &card{}, false},
+	}
+	db := gormtest.DB(t)
+	gormtest.Schema(t, db, &user{})
+	for _, tt := range tests {
+		if got := hasDeletableRelations(gormtest.Schema(t, db, tt.model)); got != tt.want {
+			t.Errorf("%s: hasDeletableRelations = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMode(t *testing.T) {
+	tests := []struct {
+		model    interface{}
+		unscoped bool
+		want     string
+	}{
+		{&card{}, false, ModeSoft},
+		{&card{}, true, ModeHard},
+		{&profile{}, false, ModeHard},
+	}
+	db := gormtest.DB(t)
+	for _, tt := range tests {
+		if got := mode(gormtest.Schema(t, db, tt.model), tt.unscoped); got != tt.want {
+			t.Errorf("mode(%T, %v) = %s, want %s", tt.model, tt.unscoped, got, tt.want)
+		}
+	}
+}
+
+func TestOptionsMaxDepth(t *testing.T) {
+	for in, want := range map[int]int{0: 5, -1: 5, 1: 1, 10: 10} {
+		if got := (Options{MaxDepth: in}).maxDepth(); got != want {
+			t.Errorf("maxDepth(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func testPlan() *Plan {
+	return &Plan{
+		Steps: []Step{
+			{Table: "user_languages", Type: TypeMany2Many, Mode: ModeHard, Rows: 2},
+			{Table: "cards", Type: TypeHasMany, Mode: ModeSoft, Rows: 3},
+			{Table: "users", Type: TypeRoot, Mode: ModeHard, Rows: 1},
+		},
+		Effects: []Effect{
+			{Constraint: "fk_orders_user", Table: "orders", Action: "cascade", Trigger: "users", Rows: 4},
+			{Constraint: "fk_order_items_order", Table: "order_items", Action: "cascade", Trigger: "fk_orders_user", Rows: 9},
+			{Constraint: "fk_users_referrer", Table: "users", Action: "set null", Trigger: "users", Rows: 1},
+		},
+	}
+}
+
+func TestPlanTables(t *testing.T) {
+	want := []string{"cards", "order_items", "orders", "user_languages", "users"}
+	if got := testPlan().tables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("tables = %v, want %v", got, want)
+	}
+}
+
+func TestCascadeDeltas(t *testing.T) {
+	plan := testPlan()
+	plan.Effects = append(plan.Effects, Effect{Constraint: "fk_cards_owner", Table: "cards", Action: "cascade", Trigger: "users", Rows: 2})
+	// cards 的软删除不会减少行数，users 没有级联所以不统计
+	want := map[string]int64{"orders": 4, "order_items": 9, "cards": 2}
+	if got := plan.cascadeDeltas(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cascadeDeltas = %v, want %v", got, want)
+	}
+
+	plan.Effects = append(plan.Effects, Effect{Constraint: "fk_users_parent", Table: "users", Action: "cascade", Trigger: "users", Rows: 2})
+	if got := plan.cascadeDeltas()["users"]; got != 3 {
+		t.Errorf("users delta = %d, want 3 (1 root + 2 cascaded)", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *Plan)
+		want   int
+	}{
+		{"same", func(p *Plan) {}, 0},
+		{"step rows", func(p *Plan) { p.Steps[1].Rows = 4 }, 1},
+		{"step mode", func(p *Plan) { p.Steps[2].Mode = ModeSoft }, 1},
+		{"step count", func(p *Plan) { p.Steps = p.Steps[1:] }, 1},
+		{"effect rows", func(p *Plan) { p.Effects[1].Rows = 10 }, 1},
+		{"effect gone", func(p *Plan) { p.Effects = p.Effects[:2] }, 1},
+		{"effect added", func(p *Plan) {
+			p.Effects = append(p.Effects, Effect{Constraint: "fk_payments_user", Table: "payments", Trigger: "users", Rows: 1})
+		}, 1},
+		{"several", func(p *Plan) { p.Steps[0].Rows = 0; p.Effects[0].Rows = 0 }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := testPlan()
+			tt.change(actual)
+			if got := compare(testPlan(), actual); len(got) != tt.want {
+				t.Errorf("compare = %q, want %d differences", got, tt.want)
+			}
+		})
+	}
+}
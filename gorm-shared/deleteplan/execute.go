@@ -0,0 +1,220 @@
+package deleteplan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MismatchError 执行时的行数和计划不一致，事务已回滚
+type MismatchError struct {
+	Diffs []string `json:"diffs"`
+	Plan  *Plan    `json:"plan"` // 事务内生成的计划，执行过的步骤带有 Affected
+}
+
+func (e *MismatchError) Error() string {
+	return "deleteplan: 实际删除的行数与计划不一致: " + strings.Join(e.Diffs, "; ")
+}
+
+// WillFailError 计划中有仍被 RESTRICT / NO ACTION 外键引用的行，没有执行
+type WillFailError struct {
+	Plan *Plan `json:"plan"`
+}
+
+func (e *WillFailError) Error() string {
+	var names []string
+	for _, effect := range e.Plan.Effects {
+		if effect.Fails {
+			names = append(names, fmt.Sprintf("%s (%s 有 %d 行)", effect.Constraint, effect.Table, effect.Rows))
+		}
+	}
+	return "deleteplan: 删除会违反外键约束 " + strings.Join(names, ", ")
+}
+
+// Execute 在一个事务里按计划删除 root
+//
+// 先锁住计划涉及的表 (SHARE ROW EXCLUSIVE，阻止其他事务写入，不影响读)，在锁内重新生成计划；
+// expected 不为 nil 时 (通常是之前 dry-run 返回的计划) 逐项比较行数，不一致返回 *MismatchError。
+// 然后按步骤执行，每一步实际影响的行数、级联删除后各表减少的行数都必须和计划一致，否则回滚。
+func Execute(db *gorm.DB, root interface{}, opts Options, expected *Plan) (*Plan, error) {
+	var plan *Plan
+	err := db.Transaction(func(tx *gorm.DB) error {
+		p, err := Build(tx, root, opts)
+		if err != nil {
+			return err
+		}
+		if err := lockTables(tx, p.tables()); err != nil {
+			return err
+		}
+		if plan, err = Build(tx, root, opts); err != nil {
+			return err
+		}
+		if expected != nil {
+			if diffs := compare(expected, plan); len(diffs) > 0 {
+				return &MismatchError{Diffs: diffs, Plan: plan}
+			}
+		}
+		if plan.WillFail {
+			return &WillFailError{Plan: plan}
+		}
+
+		expectedDelta := plan.cascadeDeltas()
+		before, err := countRows(tx, expectedDelta)
+		if err != nil {
+			return err
+		}
+
+		// 所有软删除步骤的 deleted_at 固定成同一个值，和回收站 (按相同的 deleted_at 找回一起删除的关联) 保持一致；
+		// Postgres 只保存到微秒
+		deletedAt := tx.NowFunc().Truncate(time.Microsecond)
+		tx = tx.Session(&gorm.Session{NowFunc: func() time.Time { return deletedAt }})
+
+		var diffs []string
+		for i := range plan.Steps {
+			step := &plan.Steps[i]
+			var affected int64
+			if step.Rows > 0 {
+				result := step.query(tx).Delete(step.model)
+				if result.Error != nil {
+					return result.Error
+				}
+				affected = result.RowsAffected
+			}
+			step.Affected = &affected
+			if affected != step.Rows {
+				diffs = append(diffs, fmt.Sprintf("steps[%d] %s: 计划 %d 行，实际 %d 行", i, step.Table, step.Rows, affected))
+			}
+		}
+
+		after, err := countRows(tx, expectedDelta)
+		if err != nil {
+			return err
+		}
+		for table, want := range expectedDelta {
+			got := before[table] - after[table]
+			if got != want {
+				diffs = append(diffs, fmt.Sprintf("%s: 计划减少 %d 行 (含级联删除)，实际减少 %d 行", table, want, got))
+			}
+			// 这张表只有一个级联动作时，可以算出它实际删除的行数
+			var only *Effect
+			for i := range plan.Effects {
+				if plan.Effects[i].Table == table && plan.Effects[i].Action == "cascade" {
+					if only != nil {
+						only = nil
+						break
+					}
+					only = &plan.Effects[i]
+				}
+			}
+			if only != nil {
+				n := got - (want - only.Rows)
+				only.Affected = &n
+			}
+		}
+		if len(diffs) > 0 {
+			return &MismatchError{Diffs: diffs, Plan: plan}
+		}
+		plan.Executed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// tables 计划涉及的所有表，排好序避免不同事务加锁顺序不同而死锁
+func (p *Plan) tables() []string {
+	seen := map[string]bool{}
+	var tables []string
+	add := func(table string) {
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	for _, step := range p.Steps {
+		add(step.Table)
+	}
+	for _, effect := range p.Effects {
+		add(effect.Table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func lockTables(tx *gorm.DB, tables []string) error {
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = tx.Statement.Quote(table)
+	}
+	return tx.Exec("LOCK TABLE " + strings.Join(quoted, ", ") + " IN SHARE ROW EXCLUSIVE MODE").Error
+}
+
+// cascadeDeltas 有级联删除的表预计减少的行数：计划中硬删除的行加上级联删除的行
+func (p *Plan) cascadeDeltas() map[string]int64 {
+	deltas := map[string]int64{}
+	for _, effect := range p.Effects {
+		if effect.Action == "cascade" {
+			deltas[effect.Table] += effect.Rows
+		}
+	}
+	for _, step := range p.Steps {
+		if _, ok := deltas[step.Table]; ok && step.Mode == ModeHard {
+			deltas[step.Table] += step.Rows
+		}
+	}
+	return deltas
+}
+
+func countRows(tx *gorm.DB, tables map[string]int64) (map[string]int64, error) {
+	counts := map[string]int64{}
+	for table := range tables {
+		var n int64
+		if err := tx.Session(&gorm.Session{NewDB: true}).Table(table).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
+
+// compare 逐项比较两个计划的步骤和外键动作
+func compare(expected, actual *Plan) []string {
+	var diffs []string
+	if len(expected.Steps) != len(actual.Steps) {
+		return []string{fmt.Sprintf("计划有 %d 个步骤，当前是 %d 个", len(expected.Steps), len(actual.Steps))}
+	}
+	for i, want := range expected.Steps {
+		got := actual.Steps[i]
+		if want.Table != got.Table || want.Type != got.Type || want.Mode != got.Mode {
+			diffs = append(diffs, fmt.Sprintf("steps[%d]: 计划是 %s %s (%s)，当前是 %s %s (%s)", i, want.Mode, want.Table, want.Type, got.Mode, got.Table, got.Type))
+		} else if want.Rows != got.Rows {
+			diffs = append(diffs, fmt.Sprintf("steps[%d] %s: 计划 %d 行，当前 %d 行", i, want.Table, want.Rows, got.Rows))
+		}
+	}
+
+	key := func(e Effect) string { return e.Trigger + ">" + e.Constraint }
+	current := map[string]Effect{}
+	for _, effect := range actual.Effects {
+		current[key(effect)] = effect
+	}
+	for _, want := range expected.Effects {
+		got, ok := current[key(want)]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("外键 %s 不再被触发", want.Constraint))
+			continue
+		}
+		if got.Rows != want.Rows {
+			diffs = append(diffs, fmt.Sprintf("外键 %s (%s): 计划 %d 行，当前 %d 行", want.Constraint, want.Action, want.Rows, got.Rows))
+		}
+		delete(current, key(want))
+	}
+	for _, got := range current {
+		diffs = append(diffs, fmt.Sprintf("外键 %s (%s) 新增 %d 行", got.Constraint, got.Action, got.Rows))
+	}
+	return diffs
+}
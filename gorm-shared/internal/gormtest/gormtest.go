@@ -0,0 +1,33 @@
+// Package gormtest 给 gorm-shared 各个包的测试提供不连接数据库的 *gorm.DB。
+package gormtest
+
+import (
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DB 以 DryRun 模式打开 postgres，不连接数据库，只用来解析 schema 和生成 SQL
+func DB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=gormtest"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Schema 用 db 的缓存解析模型，同一个 db 解析过的模型会带上反向关联
+func Schema(t testing.TB, db *gorm.DB, model interface{}) *schema.Schema {
+	t.Helper()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		t.Fatal(err)
+	}
+	return stmt.Schema
+}
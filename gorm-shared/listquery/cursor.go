@@ -0,0 +1,280 @@
+package listquery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 游标分页 (keyset pagination)
+//
+//	GET /users?sort=-age&per_page=50&cursor=          第一页
+//	GET /users?sort=-age&per_page=50&cursor=<token>   Link 头里 rel="next" / rel="prev" 给出的下一页、上一页
+//
+// 游标记录的是边界行排序键 (sort 的各列加上主键) 的值，而不是偏移量，
+// 下一页的条件是 (age, id) 排在边界值之后，可以走索引，也不会因为前面的行被插入或软删除而重复、遗漏。
+// 边界行本身被软删除后游标仍然有效。
+//
+// 游标用 HMAC-SHA256 签名，客户端无法篡改其中的值；游标绑定了排序方式，换了 sort 需要从第一页开始。
+
+// Secret 游标签名密钥，默认读取环境变量 LISTQUERY_CURSOR_SECRET；
+// 未设置时每次启动随机生成，进程重启后旧游标失效，多实例部署时必须设置
+var Secret = loadSecret()
+
+func loadSecret() []byte {
+	if s := os.Getenv("LISTQUERY_CURSOR_SECRET"); s != "" {
+		return []byte(s)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("listquery: generate cursor secret: %v", err))
+	}
+	return secret
+}
+
+// cursor 游标内容
+type cursor struct {
+	Sort   string    `json:"s"`           // 规范化的排序，如 "-age,id"
+	Values []*string `json:"v"`           // 边界行每个排序键的值，nil 表示 NULL
+	Back   bool      `json:"b,omitempty"` // 向前翻页，取排在边界行之前的记录
+
+	values []interface{} // 按字段类型转换后的 Values
+}
+
+var errBadCursor = errors.New("无效的游标")
+
+// sortKey 规范化的排序，用来确认游标和当前的 sort 一致
+func sortKey(sorts []Sort) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		if s.Desc {
+			parts[i] = "-" + s.Field
+		} else {
+			parts[i] = s.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (cur *cursor) encode() string {
+	b, _ := json.Marshal(cur)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + sign(payload)
+}
+
+// parseCursor 校验签名并按排序字段的类型解析边界值，token 为空表示第一页
+func (q *Query) parseCursor(token string) error {
+	if token == "" {
+		return nil
+	}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(payload))) {
+		return errBadCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errBadCursor
+	}
+	cur := &cursor{}
+	if err := json.Unmarshal(b, cur); err != nil || len(cur.Values) != len(q.Sorts) {
+		return errBadCursor
+	}
+	if cur.Sort != sortKey(q.Sorts) {
+		return fmt.Errorf("游标的排序是 %q，与当前的 sort 不一致", cur.Sort)
+	}
+
+	cur.values = make([]interface{}, len(cur.Values))
+	for i, raw := range cur.Values {
+		if raw == nil {
+			continue
+		}
+		if cur.values[i], err = convert(q.Sorts[i].field, *raw); err != nil {
+			return errBadCursor
+		}
+	}
+	q.cursor = cur
+	return nil
+}
+
+// cursorValue 把字段值转成游标里的文本，和 convert 互逆
+func cursorValue(v interface{}) (*string, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return cursorValue(rv.Elem().Interface())
+	}
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case []byte:
+		s = string(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return &s, nil
+}
+
+// rowCursor 以 row 为边界行的游标
+func (q *Query) rowCursor(ctx context.Context, row reflect.Value, back bool) (string, error) {
+	cur := &cursor{Sort: sortKey(q.Sorts), Values: make([]*string, len(q.Sorts)), Back: back}
+	row = reflect.Indirect(row)
+	for i, s := range q.Sorts {
+		v, _ := s.field.ValueOf(ctx, row)
+		var err error
+		if cur.Values[i], err = cursorValue(v); err != nil {
+			return "", err
+		}
+	}
+	return cur.encode(), nil
+}
+
+// after 排在 v 之后的条件，nil 表示没有这样的值
+// Postgres 升序时 NULL 排在最后，降序时排在最前
+func (s Sort) after(v interface{}) clause.Expression {
+	column := clause.Column{Table: clause.CurrentTable, Name: s.Field}
+	nullable := !s.field.NotNull && !s.field.PrimaryKey
+	switch {
+	case !s.Desc && v == nil:
+		return nil
+	case !s.Desc && nullable:
+		return clause.Or(clause.Gt{Column: column, Value: v}, clause.Eq{Column: column, Value: nil})
+	case !s.Desc:
+		return clause.Gt{Column: column, Value: v}
+	case v == nil:
+		return clause.Neq{Column: column, Value: nil}
+	}
+	return clause.Lt{Column: column, Value: v}
+}
+
+// keysetCondition (k1, k2, ..., id) 排在边界值之后：
+// k1 之后 OR (k1 = v1 AND k2 之后) OR ... ，每一列可以有不同的排序方向
+func keysetCondition(sorts []Sort, values []interface{}) clause.Expression {
+	var ors []clause.Expression
+	for i, s := range sorts {
+		after := s.after(values[i])
+		if after == nil {
+			continue
+		}
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			// Eq 的值为 nil 时生成 IS NULL
+			ands = append(ands, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: sorts[j].Field}, Value: values[j]})
+		}
+		ors = append(ors, clause.And(append(ands, after)...))
+	}
+	switch len(ors) {
+	case 0:
+		return clause.Expr{SQL: "FALSE"}
+	case 1:
+		// 只有一个条件的 OrConditions 会和前面的条件用 OR 连接，直接返回条件本身
+		return ors[0]
+	}
+	return clause.Or(ors...)
+}
+
+// findKeyset 按游标查询一页，多取一行判断是否还有更多
+func (q *Query) findKeyset(c *gin.Context, db *gorm.DB, dest interface{}) error {
+	ctx := c.Request.Context()
+	back := q.cursor != nil && q.cursor.Back
+
+	// 向前翻页时把每一列的方向反过来查，再把结果倒序
+	sorts := q.Sorts
+	if back {
+		sorts = make([]Sort, len(q.Sorts))
+		for i, s := range q.Sorts {
+			s.Desc = !s.Desc
+			sorts[i] = s
+		}
+	}
+
+	tx := q.Where(db.WithContext(ctx).Model(dest))
+	if q.cursor != nil {
+		tx = tx.Clauses(clause.Where{Exprs: []clause.Expression{keysetCondition(sorts, q.cursor.values)}})
+	}
+	if err := orderBy(q.Select(tx), sorts).Limit(q.PerPage + 1).Find(dest).Error; err != nil {
+		return err
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	more := rows.Len() > q.PerPage
+	if more {
+		rows.Set(rows.Slice(0, q.PerPage))
+	}
+	n := rows.Len()
+	if back {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, q.cursorURL(c.Request.URL, ""))}
+	if n > 0 {
+		// 向后翻页时，有游标说明前面还有数据；向前翻页时，后面总是有数据
+		hasPrev, hasNext := q.cursor != nil, more
+		if back {
+			hasPrev, hasNext = more, true
+		}
+		if hasPrev {
+			token, err := q.rowCursor(ctx, rows.Index(0), true)
+			if err != nil {
+				return err
+			}
+			links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, q.cursorURL(c.Request.URL, token)))
+		}
+		if hasNext {
+			token, err := q.rowCursor(ctx, rows.Index(n-1), false)
+			if err != nil {
+				return err
+			}
+			links = append(links, fmt.Sprintf(`<%s>; rel="next"`, q.cursorURL(c.Request.URL, token)))
+		}
+	}
+	c.Header("Link", strings.Join(links, ", "))
+	return nil
+}
+
+// cursorURL 替换 u 中的 cursor 参数
+func (q *Query) cursorURL(u *url.URL, token string) string {
+	values := u.Query()
+	values.Set(ParamCursor, token)
+	values.Set(ParamPerPage, strconv.Itoa(q.PerPage))
+	ref := url.URL{Path: u.Path, RawQuery: bracketUnescaper.Replace(values.Encode())}
+	return ref.String()
+}
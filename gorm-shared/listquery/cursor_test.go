@@ -0,0 +1,271 @@
+package listquery
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm-shared/datetypes"
+	"gorm-shared/internal/gormtest"
+)
+
+func parseQuery(t *testing.T, query string) *Query {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := Parse(gormtest.DB(t), &testUser{}, values, Options{})
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", query, err)
+	}
+	return q
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	email := "a@example.com"
+	created := time.Date(2024, 1, 2, 3, 4, 5, 123456789, datetypes.Location)
+	tests := []struct {
+		name string
+		sort string
+		row  testUser
+		back bool
+		want []interface{}
+	}{
+		{
+			name: "primary key only",
+			sort: "",
+			row:  testUser{ID: 7},
+			want: []interface{}{uint64(7)},
+		},
+		{
+			name: "mixed directions",
+			sort: "-age,name",
+			row:  testUser{ID: 7, Age: 30, Name: "马, \"云\""},
+			want: []interface{}{int64(30), "马, \"云\"", uint64(7)},
+		},
+		{
+			name: "null and pointer",
+			sort: "email,-score",
+			row:  testUser{ID: 1, Score: 0.1},
+			back: true,
+			want: []interface{}{nil, 0.1, uint64(1)},
+		},
+		{
+			name: "pointer value",
+			sort: "email",
+			row:  testUser{ID: 1, Email: &email},
+			want: []interface{}{email, uint64(1)},
+		},
+		{
+			name: "time and date",
+			sort: "-created_at,birthday,active",
+			row:  testUser{ID: 3, CreatedAt: created, Birthday: datetypes.NewDate(1990, 12, 31), Active: true},
+			want: []interface{}{created, datetypes.NewDate(1990, 12, 31), true, uint64(3)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseQuery(t, "sort="+url.QueryEscape(tt.sort))
+			token, err := q.rowCursor(context.Background(), reflect.ValueOf(&tt.row), tt.back)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := parseQuery(t, "sort="+url.QueryEscape(tt.sort)+"&cursor="+url.QueryEscape(token))
+			if !next.Keyset || next.cursor == nil {
+				t.Fatalf("cursor not parsed: %+v", next)
+			}
+			if next.cursor.Back != tt.back {
+				t.Errorf("back = %v, want %v", next.cursor.Back, tt.back)
+			}
+			got := next.cursor.values
+			if len(got) != len(tt.want) {
+				t.Fatalf("values = %#v, want %#v", got, tt.want)
+			}
+			for i := range got {
+				if want, ok := tt.want[i].(time.Time); ok {
+					if gotTime, ok := got[i].(time.Time); !ok || !gotTime.Equal(want) {
+						t.Errorf("values[%d] = %#v, want %v", i, got[i], want)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("values[%d] = %#v, want %#v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCursorRejects(t *testing.T) {
+	q := parseQuery(t, "sort=-age")
+	valid, err := q.rowCursor(context.Background(), reflect.ValueOf(testUser{ID: 1, Age: 20}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(valid, ".")
+	// 换一个值重新编码，但保留原来的签名
+	forged := (&cursor{Sort: "-age,id", Values: []*string{ptr("99"), ptr("1")}}).encode()
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name    string
+		sort    string
+		token   string
+		wantMsg string
+	}{
+		{"no signature", "-age", payload, errBadCursor.Error()},
+		{"bad signature", "-age", payload + "." + signature[:len(signature)-2] + "AA", errBadCursor.Error()},
+		{"forged payload", "-age", forgedPayload + "." + signature, errBadCursor.Error()},
+		{"not base64", "-age", "!!!." + sign("!!!"), errBadCursor.Error()},
+		{"not json", "-age", signed("not json"), errBadCursor.Error()},
+		{"other sort", "age", valid, "与当前的 sort 不一致"},
+		{"value count", "-age,name", valid, errBadCursor.Error()},
+		{"value type", "-age", (&cursor{Sort: "-age,id", Values: []*string{ptr("abc"), ptr("1")}}).encode(), errBadCursor.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseQuery(t, "sort="+url.QueryEscape(tt.sort))
+			err := q.parseCursor(tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("parseCursor error = %v, want %q", err, tt.wantMsg)
+			}
+			if q.cursor != nil {
+				t.Error("cursor set on error")
+			}
+		})
+	}
+
+	if err := q.parseCursor(""); err != nil || q.cursor != nil {
+		t.Errorf("empty cursor: err = %v, cursor = %+v", err, q.cursor)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func signed(raw string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(raw))
+	return payload + "." + sign(payload)
+}
+
+func TestCursorValue(t *testing.T) {
+	n := 5
+	var nilPtr *int
+	tests := []struct {
+		in   interface{}
+		want *string
+	}{
+		{nil, nil},
+		{nilPtr, nil},
+		{&n, ptr("5")},
+		{uint(7), ptr("7")},
+		{0.1, ptr("0.1")},
+		{float32(0.1), ptr("0.1")},
+		{1e21, ptr("1e+21")},
+		{[]byte("abc"), ptr("abc")},
+		{time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC), ptr("2024-01-02T03:04:05.0000006Z")},
+		{datetypes.NewDate(2024, 1, 2), ptr("2024-01-02")},
+	}
+	for _, tt := range tests {
+		got, err := cursorValue(tt.in)
+		if err != nil {
+			t.Errorf("cursorValue(%#v): %v", tt.in, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("cursorValue(%#v) = %v, want %v", tt.in, deref(got), deref(tt.want))
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
+
+func TestKeysetCondition(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		name   string
+		sort   string
+		values []interface{}
+		want   string
+	}{
+		{
+			name:   "primary key",
+			sort:   "",
+			values: []interface{}{uint64(5)},
+			want:   `"test_users"."id" > 5`,
+		},
+		{
+			name:   "descending primary key",
+			sort:   "-id",
+			values: []interface{}{uint64(5)},
+			want:   `"test_users"."id" < 5`,
+		},
+		{
+			name:   "not null column",
+			sort:   "name",
+			values: []interface{}{"a", uint64(7)},
+			want:   `("test_users"."name" > 'a' OR ("test_users"."name" = 'a' AND "test_users"."id" > 7))`,
+		},
+		{
+			name:   "nullable ascending value",
+			sort:   "email",
+			values: []interface{}{"a", uint64(7)},
+			want:   `(("test_users"."email" > 'a' OR "test_users"."email" IS NULL) OR ("test_users"."email" = 'a' AND "test_users"."id" > 7))`,
+		},
+		{
+			// 升序时 NULL 排在最后，NULL 之后只剩同样是 NULL 的行
+			name:   "nullable ascending null",
+			sort:   "email",
+			values: []interface{}{nil, uint64(7)},
+			want:   `"test_users"."email" IS NULL AND "test_users"."id" > 7`,
+		},
+		{
+			// 降序时 NULL 排在最前，NULL 之后是所有非 NULL 的行
+			name:   "nullable descending null",
+			sort:   "-email",
+			values: []interface{}{nil, uint64(7)},
+			want:   `("test_users"."email" IS NOT NULL OR ("test_users"."email" IS NULL AND "test_users"."id" > 7))`,
+		},
+		{
+			name:   "nullable descending value",
+			sort:   "-age,id",
+			values: []interface{}{int64(30), uint64(7)},
+			want:   `("test_users"."age" < 30 OR ("test_users"."age" = 30 AND "test_users"."id" > 7))`,
+		},
+		{
+			name:   "three columns",
+			sort:   "-age,name",
+			values: []interface{}{int64(30), "b", uint64(7)},
+			want: `("test_users"."age" < 30 OR ("test_users"."age" = 30 AND "test_users"."name" > 'b') OR ` +
+				`("test_users"."age" = 30 AND "test_users"."name" = 'b' AND "test_users"."id" > 7))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseQuery(t, "sort="+url.QueryEscape(tt.sort))
+			if got := whereSQL(db, keysetCondition(q.Sorts, tt.values)); got != tt.want {
+				t.Errorf("SQL = %s\nwant  %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetConditionNothingAfter(t *testing.T) {
+	// 只有一个升序的可空列且边界值是 NULL 时，没有排在后面的行
+	q := &Query{Sorts: []Sort{{Field: "email", field: testField(t, gormtest.DB(t), "Email")}}}
+	if got := whereSQL(gormtest.DB(t), keysetCondition(q.Sorts, []interface{}{nil})); got != "FALSE" {
+		t.Errorf("SQL = %s, want FALSE", got)
+	}
+}
@@ -0,0 +1,139 @@
+package listquery
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm-shared/apierror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ParamFields 稀疏字段集: ?fields=name,age 只查询、只返回这些字段
+const ParamFields = "fields"
+
+// Fieldset 请求的字段，nil 表示全部字段；nil 上的方法都可以直接调用
+type Fieldset struct {
+	fields []*schema.Field
+}
+
+// ParseFields 按 model 的 schema 解析 ?fields 的值，raw 为空时返回 nil
+func ParseFields(db *gorm.DB, model interface{}, raw string) (*Fieldset, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return parseFields(newFieldIndex(stmt.Schema, nil), raw)
+}
+
+func parseFields(fields fieldIndex, raw string) (*Fieldset, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	fs := &Fieldset{}
+	seen := map[*schema.Field]bool{}
+	var errs []apierror.FieldError
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			errs = append(errs, apierror.FieldError{
+				Field:   ParamFields,
+				Rule:    "field",
+				Param:   name,
+				Message: "未知的字段 " + name,
+				Code:    "invalid_query",
+			})
+			continue
+		}
+		if !seen[field] {
+			seen[field] = true
+			fs.fields = append(fs.fields, field)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeValidation, "查询参数无效").WithErrors(errs...)
+	}
+	return fs, nil
+}
+
+// Key 字段集的规范形式 (列名排序后用逗号连接)，用来区分同一条记录的不同表示，比如放进 ETag
+func (f *Fieldset) Key() string {
+	if f == nil {
+		return ""
+	}
+	names := make([]string, len(f.fields))
+	for i, field := range f.fields {
+		names[i] = field.DBName
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Columns 需要查询的列：请求的字段加上 always (主键、排序列、计算 ETag 用的列等)
+func (f *Fieldset) Columns(always ...string) []string {
+	if f == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var columns []string
+	for _, name := range always {
+		if !seen[name] {
+			seen[name] = true
+			columns = append(columns, name)
+		}
+	}
+	for _, field := range f.fields {
+		if !seen[field.DBName] {
+			seen[field.DBName] = true
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// Select 只查询需要的列，f 为 nil 时不修改 db
+func (f *Fieldset) Select(db *gorm.DB, always ...string) *gorm.DB {
+	if f == nil {
+		return db
+	}
+	return db.Select(f.Columns(always...))
+}
+
+// Project 把结构体或结构体切片转成只包含请求字段的 map，键和结构体序列化时的 JSON 名一致；
+// f 为 nil 时原样返回
+func (f *Fieldset) Project(ctx context.Context, value interface{}) interface{} {
+	if f == nil {
+		return value
+	}
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		rows := make([]map[string]interface{}, rv.Len())
+		for i := range rows {
+			rows[i] = f.project(ctx, reflect.Indirect(rv.Index(i)))
+		}
+		return rows
+	}
+	return f.project(ctx, rv)
+}
+
+func (f *Fieldset) project(ctx context.Context, rv reflect.Value) map[string]interface{} {
+	row := make(map[string]interface{}, len(f.fields))
+	for _, field := range f.fields {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		row[name] = field.ReflectValueOf(ctx, rv).Interface()
+	}
+	return row
+}
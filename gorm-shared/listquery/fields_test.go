@@ -0,0 +1,96 @@
+package listquery
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gorm-shared/internal/gormtest"
+)
+
+type fieldsUser struct {
+	ID       uint   `json:"id"`
+	FullName string `json:"name,omitempty" gorm:"column:full_name"`
+	Age      int    `json:"age"`
+	Nickname string
+}
+
+func testFields(t *testing.T, raw string) *Fieldset {
+	t.Helper()
+	fs, err := ParseFields(gormtest.DB(t), &fieldsUser{}, raw)
+	if err != nil {
+		t.Fatalf("ParseFields(%q): %v", raw, err)
+	}
+	return fs
+}
+
+func TestParseFieldsErrors(t *testing.T) {
+	for _, raw := range []string{"password", "name,,bogus"} {
+		if _, err := ParseFields(gormtest.DB(t), &fieldsUser{}, raw); err == nil {
+			t.Errorf("ParseFields(%q) succeeded, want error", raw)
+		}
+	}
+	if fs := testFields(t, " "); fs != nil {
+		t.Errorf("ParseFields(blank) = %+v, want nil", fs)
+	}
+}
+
+func TestFieldsetKey(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"age,name", "age,full_name"},
+		// 顺序、别名和重复都不影响 Key，同一种表示的 ETag 一致
+		{"name,age", "age,full_name"},
+		{"FullName,age,full_name", "age,full_name"},
+		{"Nickname,id", "id,nickname"},
+	}
+	for _, tt := range tests {
+		if got := testFields(t, tt.raw).Key(); got != tt.want {
+			t.Errorf("Key(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestFieldsetColumns(t *testing.T) {
+	tests := []struct {
+		raw    string
+		always []string
+		want   []string
+	}{
+		{"", []string{"id"}, nil},
+		{"name,age", nil, []string{"full_name", "age"}},
+		{"name,age", []string{"id", "updated_at"}, []string{"id", "updated_at", "full_name", "age"}},
+		// always 里已有的列和重复的 always 都只出现一次
+		{"id,name", []string{"id", "id"}, []string{"id", "full_name"}},
+	}
+	for _, tt := range tests {
+		if got := testFields(t, tt.raw).Columns(tt.always...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Columns(%q, %q) = %q, want %q", tt.raw, tt.always, got, tt.want)
+		}
+	}
+}
+
+func TestFieldsetProject(t *testing.T) {
+	ctx := context.Background()
+	u := fieldsUser{ID: 1, FullName: "马飞飞", Age: 30, Nickname: "飞"}
+
+	// 键用 JSON 名，没有 json 标签的字段用字段名
+	want := map[string]interface{}{"name": "马飞飞", "Nickname": "飞"}
+	if got := testFields(t, "full_name,Nickname").Project(ctx, &u); !reflect.DeepEqual(got, want) {
+		t.Errorf("Project(struct) = %v, want %v", got, want)
+	}
+
+	rows := []*fieldsUser{&u, {ID: 2, Age: 7}}
+	wantRows := []map[string]interface{}{{"id": uint(1), "age": 30}, {"id": uint(2), "age": 7}}
+	if got := testFields(t, "age,id").Project(ctx, rows); !reflect.DeepEqual(got, wantRows) {
+		t.Errorf("Project(slice) = %v, want %v", got, wantRows)
+	}
+
+	var fs *Fieldset
+	if got := fs.Project(ctx, &u); got != &u {
+		t.Errorf("nil Fieldset Project = %v, want the value unchanged", got)
+	}
+}
@@ -0,0 +1,494 @@
+// Package listquery 把列表接口的查询参数翻译成参数化的 GORM 查询。
+//
+// 支持的写法：
+//
+//	?age[gte]=18&name[like]=马%&birthday[lt]=2000-01-01&id[in]=1,2,3&age[null]=false
+//	&sort=-age,name&page=2&per_page=50
+//
+// 带 ?cursor 时改用基于游标的 keyset 分页，见 cursor.go；?fields=name,age 只查询和返回部分字段，见 fields.go。
+//
+// 字段名可以是 JSON 名、列名或 Go 字段名，必须能在模型的 GORM schema 里找到；
+// 列名只通过 clause.Column 引用，值全部作为绑定参数，不会拼进 SQL。
+package listquery
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm-shared/apierror"
+	"gorm-shared/datetypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 过滤运算符
+const (
+	OpEq    = "eq"
+	OpNe    = "ne"
+	OpGt    = "gt"
+	OpGte   = "gte"
+	OpLt    = "lt"
+	OpLte   = "lte"
+	OpLike  = "like"
+	OpILike = "ilike"
+	OpIn    = "in"
+	OpNin   = "nin"
+	OpNull  = "null" // ?age[null]=true 表示 IS NULL，false 表示 IS NOT NULL
+)
+
+// 保留的查询参数
+const (
+	ParamSort    = "sort"
+	ParamPage    = "page"
+	ParamPerPage = "per_page"
+	ParamCursor  = "cursor"
+)
+
+// Options 列表接口的配置，零值可用
+type Options struct {
+	// Fields 允许过滤和排序的字段 (JSON 名或列名)，为空时允许模型的所有列
+	Fields []string
+	// DefaultSort 没有 ?sort 时的排序，写法同 ?sort，默认按主键升序
+	DefaultSort string
+	// DefaultPerPage 默认每页条数，默认 20
+	DefaultPerPage int
+	// MaxPerPage 每页最多条数，默认 100
+	MaxPerPage int
+	// Reserved 由处理函数自己解释的其他查询参数，不当作过滤条件
+	Reserved []string
+}
+
+func (o Options) perPage() (def, limit int) {
+	def, limit = o.DefaultPerPage, o.MaxPerPage
+	if limit <= 0 {
+		limit = 100
+	}
+	if def <= 0 {
+		def = 20
+	}
+	if def > limit {
+		def = limit
+	}
+	return def, limit
+}
+
+// Filter 一个过滤条件
+type Filter struct {
+	Field  string      `json:"field"` // 列名
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+	column clause.Column
+}
+
+// Sort 一个排序字段
+type Sort struct {
+	Field string `json:"field"` // 列名
+	Desc  bool   `json:"desc"`
+	field *schema.Field
+}
+
+// Query 解析后的查询
+type Query struct {
+	Filters []Filter  `json:"filters"`
+	Sorts   []Sort    `json:"sort"`
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+	Keyset  bool      `json:"keyset"` // 游标分页，Page 无效
+	Fields  *Fieldset `json:"-"`      // ?fields，nil 表示全部字段
+	cursor  *cursor
+}
+
+// Offset 当前页第一条记录的偏移量
+func (q *Query) Offset() int {
+	return (q.Page - 1) * q.PerPage
+}
+
+var keyPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?:\[([a-z]+)\])?$`)
+
+// fieldIndex 按 JSON 名、列名、Go 字段名查找可用的字段
+type fieldIndex map[string]*schema.Field
+
+func newFieldIndex(sch *schema.Schema, allowed []string) fieldIndex {
+	all := fieldIndex{}
+	for _, field := range sch.Fields {
+		if field.DBName == "" || !field.Readable {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			continue
+		}
+		all[field.DBName] = field
+		all[field.Name] = field
+		if jsonName != "" {
+			all[jsonName] = field
+		}
+	}
+	if len(allowed) == 0 {
+		return all
+	}
+
+	idx := fieldIndex{}
+	for _, name := range allowed {
+		field, ok := all[name]
+		if !ok {
+			panic(fmt.Sprintf("listquery: unknown field %q in Options.Fields", name))
+		}
+		for key, f := range all {
+			if f == field {
+				idx[key] = f
+			}
+		}
+	}
+	return idx
+}
+
+// Parse 按 model 的 schema 解析查询参数，错误是 400 的 *apierror.Error，包含每个出错参数的字段错误
+func Parse(db *gorm.DB, model interface{}, values url.Values, opts Options) (*Query, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	fields := newFieldIndex(stmt.Schema, opts.Fields)
+	def, maxPerPage := opts.perPage()
+	q := &Query{Page: 1, PerPage: def}
+
+	var errs []apierror.FieldError
+	invalid := func(param, rule, format string, args ...interface{}) {
+		errs = append(errs, apierror.FieldError{
+			Field:   param,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+			Code:    "invalid_query",
+		})
+	}
+	reserved := map[string]bool{ParamSort: true, ParamPage: true, ParamPerPage: true, ParamCursor: true, ParamFields: true}
+	for _, name := range opts.Reserved {
+		reserved[name] = true
+	}
+
+	// 按参数名排序，让条件和错误的顺序固定
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if reserved[key] {
+			continue
+		}
+		vals := values[key]
+		m := keyPattern.FindStringSubmatch(key)
+		if m == nil {
+			invalid(key, "field", "无效的查询参数 %q", key)
+			continue
+		}
+		field, ok := fields[m[1]]
+		if !ok {
+			invalid(key, "field", "不支持按 %s 过滤", m[1])
+			continue
+		}
+		op := m[2]
+		if op == "" {
+			op = OpEq
+		}
+		for _, raw := range vals {
+			filter, err := newFilter(field, op, raw)
+			if err != nil {
+				invalid(key, op, "%v", err)
+				continue
+			}
+			q.Filters = append(q.Filters, filter)
+		}
+	}
+
+	sortParam := values.Get(ParamSort)
+	if sortParam == "" {
+		sortParam = opts.DefaultSort
+	}
+	seen := map[string]bool{}
+	for _, item := range strings.Split(sortParam, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		desc := strings.HasPrefix(item, "-")
+		name := strings.TrimLeft(item, "+-")
+		field, ok := fields[name]
+		if !ok {
+			invalid(ParamSort, "field", "不支持按 %s 排序", name)
+			continue
+		}
+		if seen[field.DBName] {
+			continue
+		}
+		seen[field.DBName] = true
+		q.Sorts = append(q.Sorts, Sort{Field: field.DBName, Desc: desc, field: field})
+	}
+	// 以主键兜底，保证翻页时顺序稳定
+	if pk := stmt.Schema.PrioritizedPrimaryField; pk != nil && !seen[pk.DBName] {
+		q.Sorts = append(q.Sorts, Sort{Field: pk.DBName, field: pk})
+	}
+
+	if s := values.Get(ParamPage); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			invalid(ParamPage, "min", "page 必须是正整数")
+		} else {
+			q.Page = n
+		}
+	}
+	if s := values.Get(ParamPerPage); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPerPage {
+			invalid(ParamPerPage, "max", "per_page 必须在 1 到 %d 之间", maxPerPage)
+		} else {
+			q.PerPage = n
+		}
+	}
+
+	if fs, err := parseFields(fields, values.Get(ParamFields)); err != nil {
+		errs = append(errs, apierror.From(err).Errors...)
+	} else {
+		q.Fields = fs
+	}
+
+	if _, ok := values[ParamCursor]; ok {
+		q.Keyset = true
+		if values.Get(ParamPage) != "" {
+			invalid(ParamPage, "excluded_with", "page 不能和 cursor 同时使用")
+		} else if err := q.parseCursor(values.Get(ParamCursor)); err != nil {
+			errs = append(errs, apierror.FieldError{Field: ParamCursor, Rule: "cursor", Message: err.Error(), Code: "invalid_cursor"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeValidation, "查询参数无效").WithErrors(errs...)
+	}
+	return q, nil
+}
+
+// newFilter 按字段类型转换值，类型不符的值在这里就被拒绝，不会交给数据库
+func newFilter(field *schema.Field, op, raw string) (Filter, error) {
+	filter := Filter{
+		Field:  field.DBName,
+		Op:     op,
+		column: clause.Column{Table: clause.CurrentTable, Name: field.DBName},
+	}
+	var err error
+	switch op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		if op != OpEq && op != OpNe && field.DataType == schema.Bool {
+			return filter, fmt.Errorf("%s 不支持 %s", field.DBName, op)
+		}
+		filter.Value, err = convert(field, raw)
+	case OpLike, OpILike:
+		if field.DataType != schema.String {
+			return filter, fmt.Errorf("%s 不是字符串字段，不支持 %s", field.DBName, op)
+		}
+		filter.Value = raw
+	case OpIn, OpNin:
+		parts := strings.Split(raw, ",")
+		vals := make([]interface{}, 0, len(parts))
+		for _, part := range parts {
+			v, err := convert(field, strings.TrimSpace(part))
+			if err != nil {
+				return filter, err
+			}
+			vals = append(vals, v)
+		}
+		filter.Value = vals
+	case OpNull:
+		filter.Value, err = strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("%s[null] 只能是 true 或 false", field.DBName)
+		}
+	default:
+		return filter, fmt.Errorf("未知的运算符 %q", op)
+	}
+	return filter, err
+}
+
+// convert 把查询参数转换成字段对应的 Go 值
+func convert(field *schema.Field, raw string) (interface{}, error) {
+	switch field.DataType {
+	case schema.Bool:
+		if v, err := strconv.ParseBool(raw); err == nil {
+			return v, nil
+		}
+	case schema.Int:
+		if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return v, nil
+		}
+	case schema.Uint:
+		if v, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			return v, nil
+		}
+	case schema.Float:
+		if v, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v, nil
+		}
+	case schema.Time:
+		if v, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return v, nil
+		}
+		// 只有日期时按 datetypes.Location 的零点处理
+		if d, err := datetypes.ParseDate(raw); err == nil {
+			return d.Time(), nil
+		}
+	case "date":
+		if d, err := datetypes.ParseDate(raw); err == nil {
+			return d, nil
+		}
+	default:
+		return raw, nil
+	}
+	return nil, fmt.Errorf("%q 不是有效的 %s 类型的值", raw, field.DataType)
+}
+
+// Expression 过滤条件对应的子句
+func (f Filter) Expression() clause.Expression {
+	switch f.Op {
+	case OpNe:
+		return clause.Neq{Column: f.column, Value: f.Value}
+	case OpGt:
+		return clause.Gt{Column: f.column, Value: f.Value}
+	case OpGte:
+		return clause.Gte{Column: f.column, Value: f.Value}
+	case OpLt:
+		return clause.Lt{Column: f.column, Value: f.Value}
+	case OpLte:
+		return clause.Lte{Column: f.column, Value: f.Value}
+	case OpLike:
+		return clause.Like{Column: f.column, Value: f.Value}
+	case OpILike:
+		return clause.Expr{SQL: "? ILIKE ?", Vars: []interface{}{f.column, f.Value}}
+	case OpIn:
+		return clause.IN{Column: f.column, Values: f.Value.([]interface{})}
+	case OpNin:
+		return clause.Not(clause.IN{Column: f.column, Values: f.Value.([]interface{})})
+	case OpNull:
+		if f.Value.(bool) {
+			return clause.Eq{Column: f.column, Value: nil}
+		}
+		return clause.Neq{Column: f.column, Value: nil}
+	}
+	return clause.Eq{Column: f.column, Value: f.Value}
+}
+
+// Where 附加所有过滤条件
+func (q *Query) Where(db *gorm.DB) *gorm.DB {
+	if len(q.Filters) == 0 {
+		return db
+	}
+	exprs := make([]clause.Expression, len(q.Filters))
+	for i, f := range q.Filters {
+		exprs[i] = f.Expression()
+	}
+	return db.Clauses(clause.Where{Exprs: exprs})
+}
+
+// Order 附加排序
+func (q *Query) Order(db *gorm.DB) *gorm.DB {
+	return orderBy(db, q.Sorts)
+}
+
+func orderBy(db *gorm.DB, sorts []Sort) *gorm.DB {
+	if len(sorts) == 0 {
+		return db
+	}
+	columns := make([]clause.OrderByColumn, len(sorts))
+	for i, s := range sorts {
+		columns[i] = clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: s.Field}, Desc: s.Desc}
+	}
+	return db.Clauses(clause.OrderBy{Columns: columns})
+}
+
+// Select 只查询 ?fields 请求的列，排序列也会查询，用来生成游标
+func (q *Query) Select(db *gorm.DB) *gorm.DB {
+	always := make([]string, len(q.Sorts))
+	for i, s := range q.Sorts {
+		always[i] = s.Field
+	}
+	return q.Fields.Select(db, always...)
+}
+
+// Project 按 ?fields 裁剪查询结果，没有 ?fields 时原样返回
+func (q *Query) Project(ctx context.Context, value interface{}) interface{} {
+	return q.Fields.Project(ctx, value)
+}
+
+// Paginate 附加 LIMIT / OFFSET
+func (q *Query) Paginate(db *gorm.DB) *gorm.DB {
+	return db.Limit(q.PerPage).Offset(q.Offset())
+}
+
+// Find 解析 c 的查询参数，统计总数并把当前页查询到 dest (结构体切片的指针)，
+// 同时写入 X-Total-Count 和 Link 响应头；游标分页不统计总数。
+// 带 ?fields 时只查询部分列，返回前用 q.Project 裁剪
+func Find(c *gin.Context, db *gorm.DB, dest interface{}, opts Options) (*Query, error) {
+	q, err := Parse(db, dest, c.Request.URL.Query(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if q.Keyset {
+		return q, q.findKeyset(c, db, dest)
+	}
+
+	tx := q.Where(db.WithContext(c.Request.Context()).Model(dest)).Session(&gorm.Session{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return q, err
+	}
+	if err := q.Paginate(q.Order(q.Select(tx))).Find(dest).Error; err != nil {
+		return q, err
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	if link := q.Link(c.Request.URL, total); link != "" {
+		c.Header("Link", link)
+	}
+	return q, nil
+}
+
+var bracketUnescaper = strings.NewReplacer("%5B", "[", "%5D", "]")
+
+// Link RFC 8288 格式的 first / prev / next / last 链接，保留原有的其他查询参数
+func (q *Query) Link(u *url.URL, total int64) string {
+	last := int((total + int64(q.PerPage) - 1) / int64(q.PerPage))
+	if last < 1 {
+		last = 1
+	}
+	page := func(n int) string {
+		values := u.Query()
+		values.Set(ParamPage, strconv.Itoa(n))
+		values.Set(ParamPerPage, strconv.Itoa(q.PerPage))
+		// 方括号不转义，链接更容易阅读
+		ref := url.URL{Path: u.Path, RawQuery: bracketUnescaper.Replace(values.Encode())}
+		return ref.String()
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, page(1))}
+	if q.Page > 1 {
+		prev := q.Page - 1
+		if prev > last {
+			prev = last
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, page(prev)))
+	}
+	if q.Page < last {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, page(q.Page+1)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, page(last)))
+	return strings.Join(links, ", ")
+}
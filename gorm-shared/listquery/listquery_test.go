@@ -0,0 +1,397 @@
+package listquery
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm-shared/apierror"
+	"gorm-shared/datetypes"
+	"gorm-shared/internal/gormtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type testUser struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null"`
+	Email     *string        `json:"email"`
+	Age       int            `json:"age"`
+	Score     float64        `json:"score"`
+	Active    bool           `json:"active"`
+	Birthday  datetypes.Date `json:"birthday"`
+	CreatedAt time.Time      `json:"created_at"`
+	Password  string         `json:"-"`
+}
+
+func testField(t *testing.T, db *gorm.DB, name string) *schema.Field {
+	t.Helper()
+	field := gormtest.Schema(t, db, &testUser{}).LookUpField(name)
+	if field == nil {
+		t.Fatalf("no field %q", name)
+	}
+	return field
+}
+
+// whereSQL 条件渲染成的 SQL，绑定参数已经替换成字面值
+func whereSQL(db *gorm.DB, exprs ...clause.Expression) string {
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&testUser{}).Clauses(clause.Where{Exprs: exprs}).Find(&[]testUser{})
+	})
+	_, where, _ := strings.Cut(sql, " WHERE ")
+	return where
+}
+
+type filterCase struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+func filterCases(filters []Filter) []filterCase {
+	var out []filterCase
+	for _, f := range filters {
+		out = append(out, filterCase{f.Field, f.Op, f.Value})
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		name    string
+		query   string
+		opts    Options
+		filters []filterCase
+		sort    string
+		page    int
+		perPage int
+	}{
+		{
+			name:    "defaults",
+			query:   "",
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:  "filters sorted by key",
+			query: "name[like]=马%25&age[gte]=18&active=true",
+			filters: []filterCase{
+				{"active", OpEq, true},
+				{"age", OpGte, int64(18)},
+				{"name", OpLike, "马%"},
+			},
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:  "column and go field names",
+			query: "created_at[lt]=2024-01-02T03:04:05Z&Score[gt]=1.5",
+			filters: []filterCase{
+				{"score", OpGt, 1.5},
+				{"created_at", OpLt, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:  "repeated key",
+			query: "age[ne]=1&age[ne]=2",
+			filters: []filterCase{
+				{"age", OpNe, int64(1)},
+				{"age", OpNe, int64(2)},
+			},
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:    "in and null",
+			query:   "id[in]=1, 2,3&email[null]=false",
+			filters: []filterCase{{"email", OpNull, false}, {"id", OpIn, []interface{}{uint64(1), uint64(2), uint64(3)}}},
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:    "sort with primary key appended",
+			query:   "sort=-age,+name,age&page=3&per_page=50",
+			sort:    "-age,name,id",
+			page:    3,
+			perPage: 50,
+		},
+		{
+			name:    "sort by primary key",
+			query:   "sort=-id,age",
+			sort:    "-id,age",
+			page:    1,
+			perPage: 20,
+		},
+		{
+			name:    "default sort and per page",
+			query:   "",
+			opts:    Options{DefaultSort: "-created_at", DefaultPerPage: 500, MaxPerPage: 200},
+			sort:    "-created_at,id",
+			page:    1,
+			perPage: 200,
+		},
+		{
+			name:    "reserved params are not filters",
+			query:   "q=abc&fields=name",
+			opts:    Options{Reserved: []string{"q"}},
+			sort:    "id",
+			page:    1,
+			perPage: 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := Parse(db, &testUser{}, values, tt.opts)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.query, err)
+			}
+			if got := filterCases(q.Filters); !reflect.DeepEqual(got, tt.filters) {
+				t.Errorf("filters = %#v, want %#v", got, tt.filters)
+			}
+			if got := sortKey(q.Sorts); got != tt.sort {
+				t.Errorf("sort = %q, want %q", got, tt.sort)
+			}
+			if q.Page != tt.page || q.PerPage != tt.perPage {
+				t.Errorf("page, per_page = %d, %d, want %d, %d", q.Page, q.PerPage, tt.page, tt.perPage)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		name  string
+		query string
+		opts  Options
+		want  []string // 出错的参数:规则
+	}{
+		{"unknown field", "nickname=x", Options{}, []string{"nickname:field"}},
+		{"json dash field", "password=x", Options{}, []string{"password:field"}},
+		{"bad key", "age[gte]x=1", Options{}, []string{"age[gte]x:field"}},
+		{"bad value", "age=abc", Options{}, []string{"age:eq"}},
+		{"unknown op", "age[between]=1", Options{}, []string{"age[between]:between"}},
+		{"like on int", "age[like]=1%25", Options{}, []string{"age[like]:like"}},
+		{"not allowed", "name=x", Options{Fields: []string{"age"}}, []string{"name:field"}},
+		{"sort field", "sort=-nickname", Options{}, []string{"sort:field"}},
+		{"page", "page=0", Options{}, []string{"page:min"}},
+		{"per page", "per_page=101", Options{}, []string{"per_page:max"}},
+		{"per page custom max", "per_page=11", Options{MaxPerPage: 10}, []string{"per_page:max"}},
+		{"fields", "fields=name,nickname", Options{}, []string{"fields:field"}},
+		{"page with cursor", "cursor=&page=2", Options{}, []string{"page:excluded_with"}},
+		{"bad cursor", "cursor=abc", Options{}, []string{"cursor:cursor"}},
+		{"all errors collected", "age=x&sort=foo&page=-1", Options{}, []string{"age:eq", "sort:field", "page:min"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Parse(db, &testUser{}, values, tt.opts)
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Parse(%q) error = %v, want *apierror.Error", tt.query, err)
+			}
+			if apiErr.Status != 400 || apiErr.Code != apierror.CodeValidation {
+				t.Errorf("status, code = %d, %q", apiErr.Status, apiErr.Code)
+			}
+			var got []string
+			for _, fe := range apiErr.Errors {
+				got = append(got, fe.Field+":"+fe.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFieldIndexPanicsOnUnknownField(t *testing.T) {
+	db := gormtest.DB(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown Options.Fields entry")
+		}
+	}()
+	Parse(db, &testUser{}, url.Values{}, Options{Fields: []string{"nickname"}})
+}
+
+func TestNewFilter(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		field   string
+		op      string
+		raw     string
+		want    interface{}
+		wantErr bool
+	}{
+		{"Age", OpEq, "18", int64(18), false},
+		{"Age", OpLte, "-1", int64(-1), false},
+		{"Active", OpNe, "false", false, false},
+		{"Active", OpGt, "true", nil, true},
+		{"Name", OpLike, "马%", "马%", false},
+		{"Name", OpILike, "%ma%", "%ma%", false},
+		{"Age", OpILike, "1", nil, true},
+		{"Age", OpIn, "1,2", []interface{}{int64(1), int64(2)}, false},
+		{"Age", OpNin, "1, x", nil, true},
+		{"Email", OpNull, "true", true, false},
+		{"Email", OpNull, "yes", nil, true},
+		{"Age", "between", "1,2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"["+tt.op+"]="+tt.raw, func(t *testing.T) {
+			field := testField(t, db, tt.field)
+			filter, err := newFilter(field, tt.op, tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newFilter = %#v, want error", filter.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filter.Field != field.DBName || filter.Op != tt.op || !reflect.DeepEqual(filter.Value, tt.want) {
+				t.Errorf("newFilter = %s %s %#v, want %s %s %#v", filter.Field, filter.Op, filter.Value, field.DBName, tt.op, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		field   string
+		raw     string
+		want    interface{}
+		wantErr bool
+	}{
+		{"Active", "true", true, false},
+		{"Active", "1", true, false},
+		{"Active", "yes", nil, true},
+		{"Age", "-3", int64(-3), false},
+		{"Age", "1.5", nil, true},
+		{"ID", "7", uint64(7), false},
+		{"ID", "-1", nil, true},
+		{"Score", "1e3", 1000.0, false},
+		{"Score", "NaN", nil, true},
+		{"Score", "+Inf", nil, true},
+		{"CreatedAt", "2024-01-02T03:04:05.5+08:00", time.Date(2024, 1, 2, 3, 4, 5, 5e8, time.FixedZone("", 8*3600)), false},
+		{"CreatedAt", "2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, datetypes.Location), false},
+		{"CreatedAt", "yesterday", nil, true},
+		{"Birthday", "2024/01/02", datetypes.NewDate(2024, 1, 2), false},
+		{"Birthday", "20240102", datetypes.NewDate(2024, 1, 2), false},
+		{"Birthday", "2024-02-30", nil, true},
+		{"Name", "' OR 1=1 --", "' OR 1=1 --", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.raw, func(t *testing.T) {
+			got, err := convert(testField(t, db, tt.field), tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("convert(%q) = %#v, want error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, ok := tt.want.(time.Time); ok {
+				if gotTime, ok := got.(time.Time); !ok || !gotTime.Equal(want) {
+					t.Errorf("convert(%q) = %#v, want %v", tt.raw, got, want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convert(%q) = %#v, want %#v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterExpression(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		field string
+		op    string
+		raw   string
+		want  string
+	}{
+		{"Age", OpEq, "18", `"test_users"."age" = 18`},
+		{"Age", OpNe, "18", `"test_users"."age" <> 18`},
+		{"Age", OpGt, "18", `"test_users"."age" > 18`},
+		{"Age", OpGte, "18", `"test_users"."age" >= 18`},
+		{"Age", OpLt, "18", `"test_users"."age" < 18`},
+		{"Age", OpLte, "18", `"test_users"."age" <= 18`},
+		{"Name", OpLike, "马%", `"test_users"."name" LIKE '马%'`},
+		{"Name", OpILike, "%ma%", `"test_users"."name" ILIKE '%ma%'`},
+		{"Name", OpEq, "' OR 1=1 --", `"test_users"."name" = ''' OR 1=1 --'`},
+		{"ID", OpIn, "1,2,3", `"test_users"."id" IN (1,2,3)`},
+		{"ID", OpNin, "1,2", `"test_users"."id" NOT IN (1,2)`},
+		{"Email", OpNull, "true", `"test_users"."email" IS NULL`},
+		{"Email", OpNull, "false", `"test_users"."email" IS NOT NULL`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"["+tt.op+"]="+tt.raw, func(t *testing.T) {
+			filter, err := newFilter(testField(t, db, tt.field), tt.op, tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := whereSQL(db, filter.Expression()); got != tt.want {
+				t.Errorf("SQL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryWhere(t *testing.T) {
+	db := gormtest.DB(t)
+	values, _ := url.ParseQuery("age[gte]=18&name[ilike]=%25ma%25")
+	q, err := Parse(db, &testUser{}, values, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return q.Paginate(q.Order(q.Where(tx.Model(&testUser{})))).Find(&[]testUser{})
+	})
+	want := `SELECT * FROM "test_users" WHERE "test_users"."age" >= 18 AND "test_users"."name" ILIKE '%ma%' ORDER BY "test_users"."id" LIMIT 20`
+	if sql != want {
+		t.Errorf("SQL = %s\nwant  %s", sql, want)
+	}
+}
+
+func TestOptionsPerPage(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		def, limit int
+	}{
+		{Options{}, 20, 100},
+		{Options{DefaultPerPage: 50}, 50, 100},
+		{Options{MaxPerPage: 10}, 10, 10},
+		{Options{DefaultPerPage: 30, MaxPerPage: 500}, 30, 500},
+		{Options{DefaultPerPage: -1, MaxPerPage: -1}, 20, 100},
+	}
+	for _, tt := range tests {
+		def, limit := tt.opts.perPage()
+		if def != tt.def || limit != tt.limit {
+			t.Errorf("%+v.perPage() = %d, %d, want %d, %d", tt.opts, def, limit, tt.def, tt.limit)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package replica 用 dbresolver 把读请求分发到只读副本，写请求和事务留在主库。
+//
+// 副本选择策略：
+//   - round_robin 在可用的副本之间轮询
+//   - least_lag   选择复制延迟最小的副本
+//
+// 复制延迟由后台定时比较主库的 pg_current_wal_lsn() 和副本的 pg_last_wal_replay_lsn() 得到，单位是字节。
+// 查询失败或延迟超过 MaxLagBytes 的副本不参与选择；没有可用副本时读请求回到主库。
+//
+// 开启 read-your-writes 后，同一个客户端写入之后的一段时间内读请求也走主库，见 ReadYourWrites。
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib" // 注册 pgx 的 database/sql 驱动
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// 副本选择策略
+const (
+	PolicyRoundRobin = "round_robin"
+	PolicyLeastLag   = "least_lag"
+)
+
+// Config 副本配置
+type Config struct {
+	// DSNs 副本的连接串，为空时不启用副本 (DB_REPLICAS，多个用 ; 分隔)
+	DSNs []string
+	// Policy 副本选择策略 (DB_REPLICA_POLICY，默认 round_robin)
+	Policy string
+	// MaxLagBytes 复制延迟超过这个字节数的副本不参与选择，0 表示不限制 (DB_REPLICA_MAX_LAG_BYTES)
+	MaxLagBytes int64
+	// CheckInterval 测量复制延迟的间隔 (DB_REPLICA_CHECK_INTERVAL，默认 2s)
+	CheckInterval time.Duration
+	// ReadYourWrites 客户端写入后读请求固定走主库的时长，0 表示关闭 (DB_READ_YOUR_WRITES)
+	ReadYourWrites time.Duration
+}
+
+// ConfigFromEnv 从环境变量读取配置
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Policy: PolicyRoundRobin, CheckInterval: 2 * time.Second}
+
+	for _, dsn := range strings.Split(os.Getenv("DB_REPLICAS"), ";") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			cfg.DSNs = append(cfg.DSNs, dsn)
+		}
+	}
+	if s := os.Getenv("DB_REPLICA_POLICY"); s != "" {
+		if s != PolicyRoundRobin && s != PolicyLeastLag {
+			return cfg, fmt.Errorf("invalid DB_REPLICA_POLICY %q", s)
+		}
+		cfg.Policy = s
+	}
+	if s := os.Getenv("DB_REPLICA_MAX_LAG_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid DB_REPLICA_MAX_LAG_BYTES %q", s)
+		}
+		cfg.MaxLagBytes = n
+	}
+	if s := os.Getenv("DB_REPLICA_CHECK_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid DB_REPLICA_CHECK_INTERVAL %q", s)
+		}
+		cfg.CheckInterval = d
+	}
+	if s := os.Getenv("DB_READ_YOUR_WRITES"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid DB_READ_YOUR_WRITES %q", s)
+		}
+		cfg.ReadYourWrites = d
+	}
+	return cfg, nil
+}
+
+// Status 一个副本最近一次检查的结果
+type Status struct {
+	Name       string    `json:"name"` // host:port/dbname，不包含密码
+	Healthy    bool      `json:"healthy"`
+	InRecovery bool      `json:"in_recovery"` // false 表示这是一个独立实例 (本地测试用)，延迟按 0 计算
+	ReplayLSN  string    `json:"replay_lsn,omitempty"`
+	LagBytes   int64     `json:"lag_bytes"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type replicaState struct {
+	pool *sql.DB
+
+	mu     sync.RWMutex
+	status Status
+}
+
+func (s *replicaState) get() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Resolver 副本路由；nil 表示没有配置副本，所有方法都可以直接调用
+type Resolver struct {
+	cfg      Config
+	primary  *gorm.DB
+	replicas []*replicaState
+	next     uint64
+
+	pins sync.Map // 客户端 -> 固定走主库的截止时间
+}
+
+// Register 打开副本连接，注册 dbresolver 和路由回调，并开始后台测量复制延迟
+// 没有配置副本时返回 nil
+func Register(db *gorm.DB, cfg Config) (*Resolver, error) {
+	if len(cfg.DSNs) == 0 {
+		return nil, nil
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 2 * time.Second
+	}
+
+	r := &Resolver{cfg: cfg, primary: db}
+	dialectors := make([]gorm.Dialector, len(cfg.DSNs))
+	for i, dsn := range cfg.DSNs {
+		name := dsn
+		if pc, err := pgx.ParseConfig(dsn); err == nil {
+			name = fmt.Sprintf("%s:%d/%s", pc.Host, pc.Port, pc.Database)
+		}
+		pool, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("replica %s: %w", name, err)
+		}
+		// 自己打开 *sql.DB 交给 dialector，dbresolver 传给 Policy 的连接池就是这个对象，可以和测量结果对应上
+		dialectors[i] = postgres.New(postgres.Config{Conn: pool})
+		r.replicas = append(r.replicas, &replicaState{pool: pool, status: Status{Name: name}})
+	}
+
+	err := db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   r,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.registerCallbacks(db); err != nil {
+		return nil, err
+	}
+
+	r.check(context.Background())
+	go r.monitor()
+	return r, nil
+}
+
+// Statuses 所有副本最近一次检查的结果
+func (r *Resolver) Statuses() []Status {
+	if r == nil {
+		return []Status{}
+	}
+	statuses := make([]Status, len(r.replicas))
+	for i, s := range r.replicas {
+		statuses[i] = s.get()
+	}
+	return statuses
+}
+
+// Policy 返回当前使用的策略名
+func (r *Resolver) Policy() string {
+	if r == nil {
+		return ""
+	}
+	return r.cfg.Policy
+}
+
+func (r *Resolver) monitor() {
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.check(context.Background())
+		r.expirePins()
+	}
+}
+
+// check 测量每个副本相对主库的复制延迟
+func (r *Resolver) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.CheckInterval)
+	defer cancel()
+
+	// 直接用主库连接池，不经过 dbresolver
+	primaryPool, err := r.primary.DB()
+	var primaryLSN uint64
+	if err == nil {
+		var lsn string
+		if err = primaryPool.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&lsn); err == nil {
+			primaryLSN, err = parseLSN(lsn)
+		}
+	}
+
+	for _, s := range r.replicas {
+		status := s.get()
+		status.CheckedAt = time.Now()
+		status.Error = ""
+
+		var inRecovery bool
+		var replay sql.NullString
+		replicaErr := s.pool.QueryRowContext(ctx, "SELECT pg_is_in_recovery(), pg_last_wal_replay_lsn()::text").Scan(&inRecovery, &replay)
+		switch {
+		case replicaErr != nil:
+			status.Healthy, status.Error = false, replicaErr.Error()
+		case !inRecovery:
+			// 不是流复制的备库，当作没有延迟
+			status.Healthy, status.InRecovery, status.ReplayLSN, status.LagBytes = true, false, "", 0
+		case err != nil:
+			// 主库的位置拿不到时无法判断延迟，保留上一次的结果
+			status.Healthy, status.InRecovery, status.ReplayLSN = true, true, replay.String
+			status.Error = "primary: " + err.Error()
+		default:
+			status.Healthy, status.InRecovery, status.ReplayLSN = true, true, replay.String
+			replayLSN, parseErr := parseLSN(replay.String)
+			if parseErr != nil {
+				status.Healthy, status.Error = false, parseErr.Error()
+				break
+			}
+			status.LagBytes = 0
+			if primaryLSN > replayLSN {
+				status.LagBytes = int64(primaryLSN - replayLSN)
+			}
+		}
+
+		s.mu.Lock()
+		s.status = status
+		s.mu.Unlock()
+	}
+}
+
+// parseLSN 解析 "16/B374D848" 形式的 WAL 位置
+func parseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return h<<32 | l, nil
+}
+
+// usable 副本是否可以接收读请求
+func (r *Resolver) usable(status Status) bool {
+	return status.Healthy && (r.cfg.MaxLagBytes == 0 || status.LagBytes <= r.cfg.MaxLagBytes)
+}
+
+// anyUsable 至少有一个副本可用
+func (r *Resolver) anyUsable() bool {
+	for _, s := range r.replicas {
+		if r.usable(s.get()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 实现 dbresolver.Policy，在可用的副本中按策略选择
+// 没有可用副本的情况已经由路由回调改成走主库，这里只处理检查结果刚刚变化的竞争情况
+func (r *Resolver) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	var candidates []int
+	var lags []int64
+	for i, pool := range connPools {
+		for _, s := range r.replicas {
+			if gorm.ConnPool(s.pool) != pool {
+				continue
+			}
+			if status := s.get(); r.usable(status) {
+				candidates = append(candidates, i)
+				lags = append(lags, status.LagBytes)
+			}
+		}
+	}
+	n := atomic.AddUint64(&r.next, 1)
+	if len(candidates) == 0 {
+		return connPools[n%uint64(len(connPools))]
+	}
+
+	if r.cfg.Policy == PolicyLeastLag {
+		// 延迟相同的副本之间仍然轮询
+		min := lags[0]
+		for _, lag := range lags[1:] {
+			if lag < min {
+				min = lag
+			}
+		}
+		var best []int
+		for j, lag := range lags {
+			if lag == min {
+				best = append(best, candidates[j])
+			}
+		}
+		candidates = best
+	}
+	return connPools[candidates[n%uint64(len(candidates))]]
+}
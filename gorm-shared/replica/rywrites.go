@@ -0,0 +1,193 @@
+package replica
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Read-your-writes
+//
+// 副本总是落后主库一点，客户端刚写入就去读，可能从副本上读到旧数据。
+// ReadYourWrites 中间件按客户端记录最后一次写入的时间，之后 Config.ReadYourWrites 时长内
+// 这个客户端的读请求都走主库。客户端用 X-Client-ID 请求头标识，没有时用 rw_client cookie (没有就分配一个)。
+//
+// 一个请求算作写入：方法不是 GET/HEAD/OPTIONS，或者请求期间通过 db.WithContext(c.Request.Context())
+// 执行了 INSERT/UPDATE/DELETE。同一个请求里写入之后的读也走主库。
+// 记录只保存在进程内存里，多实例部署时需要让同一个客户端落到同一个实例上。
+
+const (
+	// HeaderClientID 客户端标识请求头
+	HeaderClientID = "X-Client-ID"
+	// CookieClientID 没有 X-Client-ID 时用来标识客户端的 cookie
+	CookieClientID = "rw_client"
+	// HeaderPinned 本次请求的读是否固定走主库，方便排查
+	HeaderPinned = "X-Read-Primary"
+)
+
+type contextKey struct{}
+
+// requestState 一次请求的路由状态
+type requestState struct {
+	pinned  bool        // 客户端在窗口期内，读走主库
+	written atomic.Bool // 本次请求已经写入过
+}
+
+func stateFrom(ctx context.Context) *requestState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(contextKey{}).(*requestState)
+	return state
+}
+
+// connKey 语句原来使用的专用连接
+const connKey = "replica:conn"
+
+// registerer gorm 各个回调处理器 Before/After 之后的返回值
+type registerer interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// registerCallbacks 注册路由和记录写入的回调
+//
+// dbresolver 只保留事务的连接，db.Connection 拿到的专用连接 (*sql.Conn) 也会被换成主库或副本的连接池，
+// 会话级的 advisory lock、SET、临时表就落到了别的连接上。
+// 所以在 dbresolver 之前记下专用连接，在它之后换回来。
+func (r *Resolver) registerCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	// route 在 dbresolver 之前，restoreConn 在 dbresolver 之后、执行语句之前
+	callbacks := [][2]registerer{
+		{cb.Query().Before("*"), cb.Query().After("gorm:db_resolver").Before("gorm:query")},
+		{cb.Row().Before("*"), cb.Row().After("gorm:db_resolver").Before("gorm:row")},
+		{cb.Raw().Before("*"), cb.Raw().After("gorm:db_resolver").Before("gorm:raw")},
+		{cb.Create().Before("*"), cb.Create().After("gorm:db_resolver").Before("gorm:begin_transaction")},
+		{cb.Update().Before("*"), cb.Update().After("gorm:db_resolver").Before("gorm:begin_transaction")},
+		{cb.Delete().Before("*"), cb.Delete().After("gorm:db_resolver").Before("gorm:begin_transaction")},
+	}
+	for _, c := range callbacks {
+		if err := c[0].Register("replica:route", r.route); err != nil {
+			return err
+		}
+		if err := c[1].Register("replica:conn", restoreConn); err != nil {
+			return err
+		}
+	}
+
+	if err := cb.Create().After("gorm:create").Register("replica:track", track); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("replica:track", track); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("replica:track", track); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("replica:track", trackRaw)
+}
+
+// route 客户端在窗口期内、本次请求已经写入过、或者没有可用的副本时，读走主库
+func (r *Resolver) route(db *gorm.DB) {
+	if conn, ok := db.Statement.ConnPool.(*sql.Conn); ok {
+		db.Statement.Settings.Store(connKey, conn)
+		return
+	}
+	db.Statement.Settings.Delete(connKey)
+
+	state := stateFrom(db.Statement.Context)
+	if (state != nil && (state.pinned || state.written.Load())) || !r.anyUsable() {
+		dbresolver.Write.ModifyStatement(db.Statement)
+	}
+}
+
+// restoreConn 换回 dbresolver 之前的专用连接
+func restoreConn(db *gorm.DB) {
+	if conn, ok := db.Statement.Settings.Load(connKey); ok {
+		db.Statement.ConnPool = conn.(*sql.Conn)
+	}
+}
+
+func track(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	if state := stateFrom(db.Statement.Context); state != nil {
+		state.written.Store(true)
+	}
+}
+
+// trackRaw db.Exec 执行的不是 SELECT 时也算写入
+func trackRaw(db *gorm.DB) {
+	query := strings.TrimSpace(db.Statement.SQL.String())
+	if len(query) >= 6 && strings.EqualFold(query[:6], "select") {
+		return
+	}
+	track(db)
+}
+
+// Primary 返回固定走主库的 db，用于必须读主库的查询，比如 pg_locks、pg_stat_activity 这类只反映本实例状态的视图
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write).Session(&gorm.Session{})
+}
+
+// ReadYourWrites 返回 read-your-writes 中间件；没有配置副本或 Config.ReadYourWrites 为 0 时什么也不做
+func (r *Resolver) ReadYourWrites() gin.HandlerFunc {
+	if r == nil || r.cfg.ReadYourWrites <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		client := clientID(c)
+		state := &requestState{}
+		if until, ok := r.pins.Load(client); ok && time.Now().Before(until.(time.Time)) {
+			state.pinned = true
+			c.Header(HeaderPinned, "1")
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), contextKey{}, state))
+
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !state.written.Load() {
+				return
+			}
+		}
+		r.pins.Store(client, time.Now().Add(r.cfg.ReadYourWrites))
+	}
+}
+
+// clientID X-Client-ID 请求头，其次是 rw_client cookie，都没有时分配一个新的 cookie
+func clientID(c *gin.Context) string {
+	if id := c.GetHeader(HeaderClientID); id != "" {
+		return "h:" + id
+	}
+	if id, err := c.Cookie(CookieClientID); err == nil && id != "" {
+		return "c:" + id
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CookieClientID, id, 0, "/", "", false, true)
+	return "c:" + id
+}
+
+// expirePins 清理已经过期的记录
+func (r *Resolver) expirePins() {
+	now := time.Now()
+	r.pins.Range(func(key, value interface{}) bool {
+		if !now.Before(value.(time.Time)) {
+			r.pins.Delete(key)
+		}
+		return true
+	})
+}
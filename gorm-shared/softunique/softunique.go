@@ -0,0 +1,299 @@
+// Package softunique 为软删除的模型创建只约束未删除记录的唯一索引。
+//
+// 普通的唯一索引把软删除的行也算在内，删除 "马飞飞" 之后再创建同名用户会违反唯一约束。
+// 把 DeletedAt 加进唯一索引也不行：NULL 互不相等，两条未删除的同名记录都能插入。
+// 这里改用 Postgres 的部分索引，只约束 deleted_at IS NULL 的行，模型仍然使用 gorm.DeletedAt：
+//
+//	CREATE UNIQUE INDEX udx_users_name ON users (name) WHERE deleted_at IS NULL
+//
+// 在 gorm 标签里用 liveUnique 声明，名字相同的字段组成复合索引，不写名字时为 udx_<表名>_<列名>：
+//
+//	type User struct {
+//		gorm.Model
+//		Name   string `gorm:"liveUnique"`
+//		Tenant string `gorm:"liveUnique:udx_users_tenant_email"`
+//		Email  string `gorm:"liveUnique:udx_users_tenant_email"`
+//	}
+//
+// Migrate 在 AutoMigrate 之后执行：删除同样列上的普通唯一索引或唯一约束，处理已有的重复数据，再创建部分索引。
+// 迁移时会锁表阻止写入，适合放在单独的迁移命令里，而不是每次启动都执行。
+package softunique
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// TagKey gorm 标签里声明 "在未删除的记录中唯一" 的键 (gorm 解析标签时转成大写)
+const TagKey = "LIVEUNIQUE"
+
+// Index 一个只约束未删除记录的唯一索引
+type Index struct {
+	Name      string   `json:"name"`
+	Table     string   `json:"table"`
+	Columns   []string `json:"columns"`
+	DeletedAt string   `json:"deleted_at"` // 软删除列
+}
+
+// Options 迁移参数
+type Options struct {
+	// Dedupe 未删除的记录里已经有重复值时，每组保留主键最小的一行，其余软删除；
+	// 为 false 时遇到重复数据返回 *DuplicateError，不做任何修改
+	Dedupe bool
+	// Trash 去重时软删除 ids (主键的文本形式) 对应的行，返回删除的行数；在迁移的事务里调用，返回错误时整个索引回滚。
+	// 应用通常在这里调用自己的删除逻辑，让 BeforeDelete 钩子和子记录的级联软删除照常生效。
+	// 为 nil 时直接 UPDATE deleted_at = now()，不经过钩子，也不处理子记录
+	Trash func(tx *gorm.DB, ids []string) (int64, error)
+}
+
+// Report 一个索引的迁移结果
+type Report struct {
+	Index
+	Created      bool     `json:"created"`           // false 表示索引已经存在
+	Dropped      []string `json:"dropped,omitempty"` // 删除的普通唯一索引或唯一约束
+	Duplicates   int64    `json:"duplicates"`        // 迁移前重复的组数
+	Deduplicated int64    `json:"deduplicated"`      // 被软删除的行数
+	Kept         []string `json:"kept,omitempty"`    // 每组保留的主键
+	Removed      []string `json:"removed,omitempty"` // 被软删除的主键
+	Error        string   `json:"error,omitempty"`
+}
+
+// DuplicateError 未删除的记录里有重复值，不能创建唯一索引
+type DuplicateError struct {
+	Index  string
+	Groups int64
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("softunique: %d 组未删除的记录在 %s 上重复，先处理重复数据或使用 Dedupe", e.Groups, e.Index)
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// Indexes 按 liveUnique 标签解析模型需要的索引
+func Indexes(db *gorm.DB, model interface{}) ([]Index, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+
+	var deletedAt string
+	for _, field := range s.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			deletedAt = field.DBName
+			break
+		}
+	}
+
+	var indexes []Index
+	byName := map[string]int{}
+	for _, field := range s.Fields {
+		name, ok := field.TagSettings[TagKey]
+		if !ok || field.DBName == "" {
+			continue
+		}
+		if deletedAt == "" {
+			return nil, fmt.Errorf("softunique: %s 没有 gorm.DeletedAt 字段，%s 不需要 liveUnique", s.Name, field.Name)
+		}
+		if name == TagKey || name == "" {
+			name = "udx_" + s.Table + "_" + field.DBName
+		}
+		if i, ok := byName[name]; ok {
+			indexes[i].Columns = append(indexes[i].Columns, field.DBName)
+			continue
+		}
+		byName[name] = len(indexes)
+		indexes = append(indexes, Index{Name: name, Table: s.Table, Columns: []string{field.DBName}, DeletedAt: deletedAt})
+	}
+	return indexes, nil
+}
+
+// Migrate 为每个模型创建 liveUnique 声明的索引，每个索引一个事务
+// 出错时返回已经处理的结果和错误，已经提交的索引不会回滚
+func Migrate(db *gorm.DB, opts Options, models ...interface{}) ([]Report, error) {
+	var reports []Report
+	for _, model := range models {
+		indexes, err := Indexes(db, model)
+		if err != nil {
+			return reports, err
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return reports, err
+		}
+		for _, index := range indexes {
+			report := Report{Index: index}
+			// 先不加锁检查：索引已经存在、也没有要删除的普通唯一索引时什么都不做，不阻塞其他写入
+			done, err := upToDate(db, index)
+			if err != nil {
+				report.Error = err.Error()
+				reports = append(reports, report)
+				return reports, err
+			}
+			if done {
+				reports = append(reports, report)
+				continue
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return migrateIndex(tx, stmt.Schema, opts, &report)
+			})
+			if err != nil {
+				report.Error = err.Error()
+				reports = append(reports, report)
+				return reports, err
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func migrateIndex(tx *gorm.DB, s *schema.Schema, opts Options, report *Report) error {
+	if s.PrioritizedPrimaryField == nil {
+		return fmt.Errorf("softunique: %s 没有主键", s.Name)
+	}
+	quote := tx.Statement.Quote
+	table := quote(report.Table)
+	pk := quote(s.PrioritizedPrimaryField.DBName)
+	deletedAt := quote(report.DeletedAt)
+	columns := make([]string, len(report.Columns))
+	for i, column := range report.Columns {
+		columns[i] = quote(column)
+	}
+	cols := strings.Join(columns, ", ")
+
+	// 迁移期间阻止写入，避免去重之后、建索引之前又插入重复数据；读不受影响
+	if err := tx.Exec("LOCK TABLE " + table + " IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+		return err
+	}
+
+	dropped, err := dropFullUnique(tx, report.Table, report.Columns)
+	if err != nil {
+		return err
+	}
+	report.Dropped = dropped
+
+	if tx.Migrator().HasIndex(report.Table, report.Name) {
+		return nil
+	}
+
+	// 未删除且列都不为 NULL 的重复行，rn = 1 是每组主键最小的一行
+	notNull := make([]string, len(columns))
+	for i, column := range columns {
+		notNull[i] = column + " IS NOT NULL"
+	}
+	ranked := fmt.Sprintf(`SELECT %s::text AS id, row_number() OVER (PARTITION BY %s ORDER BY %s) AS rn, count(*) OVER (PARTITION BY %s) AS n
+		FROM %s WHERE %s IS NULL AND %s`,
+		pk, cols, pk, cols, table, deletedAt, strings.Join(notNull, " AND "))
+
+	var rows []struct {
+		ID string
+		Rn int64
+	}
+	if err := tx.Raw("SELECT id, rn FROM (" + ranked + ") d WHERE n > 1 ORDER BY id").Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.Rn == 1 {
+			report.Duplicates++
+			report.Kept = append(report.Kept, row.ID)
+		} else {
+			report.Removed = append(report.Removed, row.ID)
+		}
+	}
+	if report.Duplicates > 0 {
+		if !opts.Dedupe {
+			report.Removed = nil
+			return &DuplicateError{Index: report.Name, Groups: report.Duplicates}
+		}
+		if opts.Trash != nil {
+			if report.Deduplicated, err = opts.Trash(tx, report.Removed); err != nil {
+				return err
+			}
+		} else {
+			result := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = now() WHERE %s::text IN (SELECT id FROM (%s) d WHERE rn > 1)",
+				table, deletedAt, pk, ranked))
+			if result.Error != nil {
+				return result.Error
+			}
+			report.Deduplicated = result.RowsAffected
+		}
+	}
+
+	err = tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s) WHERE %s IS NULL",
+		quote(report.Name), table, cols, deletedAt)).Error
+	if err != nil {
+		return err
+	}
+	report.Created = true
+	return nil
+}
+
+// upToDate 部分索引已经存在，并且同样列上没有普通唯一索引
+func upToDate(db *gorm.DB, index Index) (bool, error) {
+	if !db.Migrator().HasIndex(index.Table, index.Name) {
+		return false, nil
+	}
+	existing, err := fullUnique(db, index.Table, index.Columns)
+	return len(existing) == 0, err
+}
+
+type uniqueIndex struct {
+	Name       string
+	Constraint bool
+}
+
+// fullUnique 同样列上不带 WHERE 的唯一索引 (主键除外)
+func fullUnique(tx *gorm.DB, table string, columns []string) ([]uniqueIndex, error) {
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+
+	var existing []uniqueIndex
+	err := tx.Raw(`
+		SELECT i.relname AS name, c.conname IS NOT NULL AS "constraint"
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		LEFT JOIN pg_constraint c ON c.conindid = x.indexrelid AND c.contype = 'u'
+		WHERE x.indrelid = ?::regclass AND x.indisunique AND NOT x.indisprimary AND x.indpred IS NULL
+			AND (SELECT array_agg(a.attname::text ORDER BY a.attname)
+				FROM pg_attribute a
+				WHERE a.attrelid = x.indrelid AND a.attnum = ANY (x.indkey)) = ?::text[]`,
+		table, "{"+strings.Join(sorted, ",")+"}",
+	).Scan(&existing).Error
+	return existing, err
+}
+
+// dropFullUnique 删除同样列上不带 WHERE 的唯一索引，属于唯一约束的用 DROP CONSTRAINT
+func dropFullUnique(tx *gorm.DB, table string, columns []string) ([]string, error) {
+	existing, err := fullUnique(tx, table, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, index := range existing {
+		if index.Constraint {
+			err = tx.Exec("ALTER TABLE " + tx.Statement.Quote(table) + " DROP CONSTRAINT " + tx.Statement.Quote(index.Name)).Error
+		} else {
+			err = tx.Exec("DROP INDEX " + tx.Statement.Quote(index.Name)).Error
+		}
+		if err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, index.Name)
+	}
+	return dropped, nil
+}
+
+// IsDuplicate err 是否因为已有重复数据而没有创建索引
+func IsDuplicate(err error) bool {
+	var dup *DuplicateError
+	return errors.As(err, &dup)
+}
@@ -0,0 +1,97 @@
+package softunique
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gorm-shared/internal/gormtest"
+	"gorm.io/gorm"
+)
+
+type account struct {
+	gorm.Model
+	Name  string `gorm:"liveUnique"`
+	Email string `gorm:"liveUnique:udx_accounts_tenant_email"`
+	// 同名的索引合并成组合索引，列的顺序和字段顺序一致
+	TenantID uint `gorm:"liveUnique:udx_accounts_tenant_email"`
+	Age      int
+}
+
+type removedAt struct {
+	ID      uint
+	Code    string         `gorm:"liveUnique"`
+	Removed gorm.DeletedAt `gorm:"column:removed_at"`
+}
+
+type hardDeleted struct {
+	ID   uint
+	Code string `gorm:"liveUnique"`
+}
+
+type plain struct {
+	gorm.Model
+	Code string `gorm:"uniqueIndex"`
+}
+
+func TestIndexes(t *testing.T) {
+	db := gormtest.DB(t)
+	tests := []struct {
+		name    string
+		model   interface{}
+		want    []Index
+		wantErr bool
+	}{
+		{
+			name:  "default and composite names",
+			model: &account{},
+			want: []Index{
+				{Name: "udx_accounts_name", Table: "accounts", Columns: []string{"name"}, DeletedAt: "deleted_at"},
+				{Name: "udx_accounts_tenant_email", Table: "accounts", Columns: []string{"email", "tenant_id"}, DeletedAt: "deleted_at"},
+			},
+		},
+		{
+			name:  "custom deleted_at column",
+			model: &removedAt{},
+			want:  []Index{{Name: "udx_removed_ats_code", Table: "removed_ats", Columns: []string{"code"}, DeletedAt: "removed_at"}},
+		},
+		{name: "no soft delete", model: &hardDeleted{}, wantErr: true},
+		{name: "no liveUnique", model: &plain{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Indexes(db, tt.model)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Indexes = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Indexes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	dup := &DuplicateError{Index: "udx_users_name", Groups: 2}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{dup, true},
+		{fmt.Errorf("migrate users: %w", dup), true},
+		{errors.New("duplicate"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsDuplicate(tt.err); got != tt.want {
+			t.Errorf("IsDuplicate(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package txretry 在序列化失败 (40001) 或死锁 (40P01) 时重试整个事务。
+//
+//	report, err := txretry.Run(db, txretry.DefaultPolicy, func(tx *gorm.DB) error { ... })
+//
+// fn 可能被执行多次，不能在里面做事务之外的副作用。
+package txretry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// 可以安全重试的 Postgres 错误码
+const (
+	pgSerializationFailure = "40001" // serialization_failure
+	pgDeadlockDetected     = "40P01" // deadlock_detected
+)
+
+// Policy 事务重试策略：最多尝试 MaxAttempts 次，退避时间按指数增长并加随机抖动
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// OnRetry 每次决定重试、开始等待之前调用，可以用来记日志；为 nil 时只记在 Report 里
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// DefaultPolicy 默认最多尝试 5 次，退避时间从 50ms 开始，不超过 2s
+var DefaultPolicy = Policy{
+	MaxAttempts: 5,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// Report 一次事务执行的结果统计
+type Report struct {
+	Attempts int      `json:"attempts"`
+	Retries  []string `json:"retries,omitempty"` // 每次重试前遇到的错误
+	Elapsed  string   `json:"elapsed"`
+}
+
+// IsRetryable 判断错误是否是序列化失败或死锁，这两类错误整个事务重跑一次通常就能成功
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+}
+
+// backoff 第 attempt 次失败后的等待时间 (full jitter)
+func (p Policy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Run 包装 db.Transaction，遇到 40001 / 40P01 时按策略重试整个事务
+// fn 可能被执行多次，不能在里面做事务之外的副作用
+func Run(db *gorm.DB, policy Policy, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) (Report, error) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	var report Report
+	start := time.Now()
+
+	for {
+		report.Attempts++
+		err := db.Transaction(fn, opts...)
+		if err == nil || !IsRetryable(err) || report.Attempts >= policy.MaxAttempts {
+			report.Elapsed = time.Since(start).String()
+			return report, err
+		}
+
+		report.Retries = append(report.Retries, err.Error())
+		delay := policy.backoff(report.Attempts)
+		if policy.OnRetry != nil {
+			policy.OnRetry(report.Attempts, delay, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			report.Elapsed = time.Since(start).String()
+			return report, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package versioning 给带 version 列的模型实现乐观锁的版本号：每次 UPDATE 都把 version 加一。
+//
+// 版本号由回调统一维护，不要在 UPDATE 里手写 version = version + 1；
+// 原生 SQL (Exec/Raw) 不经过回调，写带版本号的表要用 Model(...).Update/Updates。
+package versioning
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+)
+
+const versionSetKey = "version:set"
+
+// Register 让所有更新路径都把 version 加一: Update、Updates、Save、UpdateColumn，以及 Select/Omit 的各种写法
+//
+// BeforeUpdate 钩子里的 SetColumn 只能用于 map，结构体字段不能赋值为 gorm.Expr，
+// 所以在 gorm:update 之前自己生成 SET 子句，再加上 version = version + 1
+func Register(db *gorm.DB) error {
+	if err := db.Callback().Update().After("gorm:before_update").Before("gorm:update").Register("version:increment", incrementVersion); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:update").Register("version:cleanup", func(db *gorm.DB) {
+		if _, ok := db.Statement.Settings.LoadAndDelete(versionSetKey); ok {
+			delete(db.Statement.Clauses, "SET")
+		}
+	})
+}
+
+func incrementVersion(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.SQL.Len() > 0 {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("version")
+	if field == nil {
+		return
+	}
+	if _, ok := db.Statement.Clauses["SET"]; ok {
+		return
+	}
+
+	set := callbacks.ConvertToAssignments(db.Statement)
+	if len(set) == 0 {
+		return
+	}
+	increment := clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: gorm.Expr("? + 1", clause.Column{Name: field.DBName})}
+	found := false
+	for i, assignment := range set {
+		if assignment.Column.Name != field.DBName {
+			continue
+		}
+		found = true
+		// 显式写了 gorm.Expr("version + 1") 的保持不变；Save 带上的旧值要换成加一
+		if _, ok := assignment.Value.(clause.Expr); !ok {
+			set[i] = increment
+		}
+	}
+	if !found {
+		set = append(set, increment)
+	}
+	db.Statement.AddClause(set)
+	db.Statement.Settings.Store(versionSetKey, true)
+}
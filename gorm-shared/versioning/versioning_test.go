@@ -0,0 +1,71 @@
+package versioning
+
+import (
+	"strings"
+	"testing"
+
+	"gorm-shared/internal/gormtest"
+	"gorm.io/gorm"
+)
+
+type doc struct {
+	ID      uint
+	Title   string
+	Version int64
+}
+
+type plain struct {
+	ID    uint
+	Title string
+}
+
+func TestRegister(t *testing.T) {
+	db := gormtest.DB(t)
+	if err := Register(db); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		update func(tx *gorm.DB) *gorm.DB
+		want   string // SET 子句
+	}{
+		{
+			name:   "update column",
+			update: func(tx *gorm.DB) *gorm.DB { return tx.Model(&doc{ID: 1}).Update("title", "a") },
+			want:   `"title"='a',"version"="version" + 1`,
+		},
+		{
+			name:   "updates struct",
+			update: func(tx *gorm.DB) *gorm.DB { return tx.Model(&doc{ID: 1}).Updates(doc{Title: "a"}) },
+			want:   `"title"='a',"version"="version" + 1`,
+		},
+		{
+			// Save 带上的旧版本号换成加一
+			name:   "save",
+			update: func(tx *gorm.DB) *gorm.DB { return tx.Save(&doc{ID: 1, Title: "a", Version: 3}) },
+			want:   `"title"='a',"version"="version" + 1`,
+		},
+		{
+			name: "explicit expression kept",
+			update: func(tx *gorm.DB) *gorm.DB {
+				return tx.Model(&doc{ID: 1}).Updates(map[string]interface{}{"version": gorm.Expr("version + 2")})
+			},
+			want: `"version"=version + 2`,
+		},
+		{
+			name:   "no version column",
+			update: func(tx *gorm.DB) *gorm.DB { return tx.Model(&plain{ID: 1}).Update("title", "a") },
+			want:   `"title"='a'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql := db.ToSQL(tt.update)
+			_, set, _ := strings.Cut(sql, " SET ")
+			set, _, _ = strings.Cut(set, " WHERE ")
+			if set != tt.want {
+				t.Errorf("SET %s, want %s\n%s", set, tt.want, sql)
+			}
+		})
+	}
+}
@@ -1,12 +1,13 @@
 module gorm-transaction
 
-go 1.23.0
+go 1.24
 
 toolchain go1.24.3
 
 require (
+	gorm-shared v0.0.0
 	gorm.io/driver/postgres v1.6.0
-	gorm.io/gorm v1.25.10
+	gorm.io/gorm v1.30.0
 )
 
 require (
@@ -20,3 +21,5 @@ require (
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
 )
+
+replace gorm-shared => ../gorm-shared
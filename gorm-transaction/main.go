@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"time"
 
+	"gorm-shared/txretry"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -58,8 +60,8 @@ func main() {
 
 	db.AutoMigrate(&User{}, &Language{}, &UserLanguage{})
 
-	// 事务
-	db.Transaction(func(tx *gorm.DB) error {
+	// 事务，遇到序列化失败 (40001) 或死锁 (40P01) 时自动重试
+	report, err := txretry.Run(db, txretry.DefaultPolicy, func(tx *gorm.DB) error {
 		if err := tx.Create(&User{Name: "犬夜叉🐶", Age: 100}).Error; err != nil {
 			return err
 		}
@@ -78,5 +80,6 @@ func main() {
 		// return errors.New("test error")
 		return nil
 	})
+	fmt.Printf("事务执行了 %d 次, 耗时 %s, 错误: %v\n", report.Attempts, report.Elapsed, err)
 
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"gorm-shared/versioning"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -21,6 +22,7 @@ type User struct {
 	Birthday time.Time `json:"birthday"`
 	LockTest string    `json:"lock_test"`
 	Role     string    `json:"role" gorm:"default:user"`
+	Version  int64     `json:"version" gorm:"not null;default:1"` // 乐观锁版本号
 }
 
 func (u *User) BeforeSave(tx *gorm.DB) (err error) {
@@ -90,6 +92,12 @@ func main() {
 		panic("failed to connect database")
 	}
 
+	db.AutoMigrate(&User{})
+	// 所有更新都让 version + 1，见 gorm-shared/versioning
+	if err := versioning.Register(db); err != nil {
+		panic(err)
+	}
+
 	// 保存所有字段
 	// user := User{
 	// 	Name:     "Adam",
@@ -276,6 +284,24 @@ func main() {
 	}
 	fmt.Println(string(jsonBytes))
 
+	// 乐观锁：更新时带上读取到的 version，version + 1 由 version:increment 回调加上 (上面的各种更新也一样)
+	// 如果这期间别人已经改过这一行，version 对不上，RowsAffected 为 0
+	var versioned User
+	if err := db.First(&versioned, 3).Error; err == nil {
+		first := db.Model(&User{}).Where("id = ? AND version = ?", versioned.ID, versioned.Version).Updates(map[string]interface{}{
+			"lock_test": "optimistic update 1",
+		})
+		fmt.Println("第一次更新影响行数:", first.RowsAffected)
+
+		// 仍然使用旧的 version，模拟另一个客户端的并发修改
+		second := db.Model(&User{}).Where("id = ? AND version = ?", versioned.ID, versioned.Version).Updates(map[string]interface{}{
+			"lock_test": "optimistic update 2",
+		})
+		if second.Error == nil && second.RowsAffected == 0 {
+			fmt.Printf("版本冲突: version %d 已过期，第二次更新被拒绝\n", versioned.Version)
+		}
+	}
+
 	// 检查字段是否有变更
 	// GORM provides the Changed method which could be used in Before Update Hooks, it will return whether the field has changed or not.
 